@@ -0,0 +1,62 @@
+/*
+import-resources creates or updates web crawler resources from a CSV or JSONL
+file, the same way as the admin import endpoint but without its size limit.
+
+Usage:
+
+	go run ./cmd/import-resources -file resources.csv -dry-run
+	go run ./cmd/import-resources -file resources.jsonl
+
+Run it from the directory holding the backend's .env. Resources are matched
+on django_relative_path; see database.ImportResources for the columns. The
+server creates the columns an import needs, so start it once against a new
+database first. A running server keeps serving cached listings until they
+expire or it restarts.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/database"
+)
+
+func main() {
+	path := flag.String("file", "", "CSV or JSONL file to import")
+	format := flag.String("format", "", "csv or jsonl (defaults to the file extension)")
+	dryRun := flag.Bool("dry-run", false, "report what would change without saving")
+	flag.Parse()
+
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = database.ImportFormatFromName(*path)
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *path, err)
+	}
+	defer file.Close()
+
+	db := config.ConnectDB()
+	config.InitTimezone()
+
+	report, err := database.ImportResources(db, file, *format, *dryRun)
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to print report: %v", err)
+	}
+	log.Printf("%d rows: %d created, %d updated (%d restored), %d rejected", report.Rows, report.Created, report.Updated, report.Restored, report.Invalid)
+}
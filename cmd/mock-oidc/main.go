@@ -0,0 +1,38 @@
+/*
+mock-oidc runs a fake OpenID Connect provider for developing "Sign in with
+Google" offline.
+
+Usage:
+
+	go run ./cmd/mock-oidc -addr :8091 -client-id local -client-secret local
+
+Then start the backend with GOOGLE_ISSUER=http://localhost:8091 and the same
+GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET. See the oidc/mock package for how
+to pick the identity to sign in as.
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/oidc/mock"
+)
+
+func main() {
+	addr := flag.String("addr", ":8091", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:8091", "public URL of the provider, used as the token issuer")
+	clientID := flag.String("client-id", "", "client ID to accept (any if empty)")
+	clientSecret := flag.String("client-secret", "", "client secret to accept (any if empty)")
+	flag.Parse()
+
+	server := mock.NewServer(mock.Options{
+		Issuer:       *issuer,
+		ClientID:     *clientID,
+		ClientSecret: *clientSecret,
+	})
+
+	log.Printf("Mock OIDC provider running on %s (issuer: %s)", *addr, *issuer)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
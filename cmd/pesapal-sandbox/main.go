@@ -0,0 +1,40 @@
+/*
+pesapal-sandbox runs a fake Pesapal API for offline development.
+
+Usage:
+
+	go run ./cmd/pesapal-sandbox -addr :8090 -scenario success
+
+Then start the backend with PESAPAL_BASE_URL=http://localhost:8090 and
+PESAPAL_IPN_URL pointing at the backend's /v1/api/payments/ipn route.
+See the sandbox package for the available scenarios and control endpoints.
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal/sandbox"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	baseURL := flag.String("base-url", "http://localhost:8090", "public URL of the sandbox, used in redirect URLs")
+	scenario := flag.String("scenario", sandbox.ScenarioSuccess, "success, failure, delayed or manual")
+	delay := flag.Duration("delay", 10*time.Second, "how long orders stay pending in the delayed scenario")
+	ipnDelay := flag.Duration("ipn-delay", time.Second, "wait before firing IPN callbacks")
+	flag.Parse()
+
+	server := sandbox.NewServer(sandbox.Options{
+		Scenario: *scenario,
+		Delay:    *delay,
+		IPNDelay: *ipnDelay,
+		BaseURL:  *baseURL,
+	})
+
+	log.Printf("Pesapal sandbox running on %s (scenario: %s)", *addr, *scenario)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -26,6 +26,13 @@ Global variable that converts time to "Africa/Nairobi"
 */
 var EAT *time.Location
 
+/*
+How close (pg_trgm word similarity, 0 to 1) a resource name or directory must
+be to the search text for a fuzzy match. It is set on every connection so the
+index-backed <% operator uses it.
+*/
+const WordSimilarityThreshold = 0.5
+
 // ConnectDB establishes a connection to the PostgreSQL database using GORM.
 // It loads environment variables from a .env file to construct the Data Source Name (DSN)
 // and uses the DSN to open the database connection. If the connection is successful,
@@ -60,12 +67,13 @@ func ConnectDB() *gorm.DB {
 	   using environment variables
 	*/
 	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable options='-c pg_trgm.word_similarity_threshold=%g'",
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_USER"),
 		os.Getenv("DB_PASSWORD"),
 		os.Getenv("DB_NAME"),
 		os.Getenv("DB_PORT"),
+		WordSimilarityThreshold,
 	)
 
 	/*
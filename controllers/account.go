@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/* Directory uploaded resumes are saved in; see TutoringController and JobsController */
+const resumeDir = "uploads/resumes"
+
+/*
+accountDeletionGracePeriod returns how long a deletion request waits before
+the account is erased. Configured with ACCOUNT_DELETION_GRACE_DAYS (default 14).
+*/
+func accountDeletionGracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		days = 14
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+/*
+userDataExport is everything stored about a user. Tutoring, job and contact
+forms are not linked to accounts, so they are matched by email address, and
+only once the user has verified it: anyone can register with someone else's
+address.
+*/
+type userDataExport struct {
+	ExportedAt         time.Time                  `json:"exported_at"`
+	Profile            models.UserResponse        `json:"profile"`
+	BillingProfile     *models.BillingProfile     `json:"billing_profile"`
+	Bookmarks          []models.Bookmark          `json:"bookmarks"`
+	Orders             []models.Order             `json:"orders"`
+	Receipts           []models.Receipt           `json:"receipts"`
+	Subscriptions      []models.Subscription      `json:"subscriptions"`
+	Sessions           []models.Session           `json:"sessions"`
+	Identities         []models.UserIdentity      `json:"identities"`
+	TutorRequests      []models.TutorRequest      `json:"tutor_requests"`
+	TutorApplications  []models.TutorApplication  `json:"tutor_applications"`
+	TeacherJobProfiles []models.TeacherJobProfile `json:"teacher_job_profiles"`
+	SchoolJobListings  []models.SchoolJobListing  `json:"school_job_listings"`
+	WebDevRequests     []models.WebDevRequest     `json:"web_dev_requests"`
+	Feedback           []models.Feedback          `json:"feedback"`
+}
+
+/* verifiedEmail returns the user's lower-cased email address if they have proved they own it, or "" */
+func verifiedEmail(user *models.User) string {
+	if user.Email == "" || user.EmailVerifiedAt == nil {
+		return ""
+	}
+	return strings.ToLower(user.Email)
+}
+
+/* collectUserData loads every record belonging to a user for an export */
+func collectUserData(db *gorm.DB, user *models.User) (*userDataExport, error) {
+	export := &userDataExport{
+		ExportedAt: time.Now().In(config.EAT),
+		Profile:    user.Response(),
+	}
+
+	var profile models.BillingProfile
+	if err := db.Where("user_id = ?", user.ID).First(&profile).Error; err == nil {
+		export.BillingProfile = &profile
+	}
+
+	queries := []struct {
+		dest  interface{}
+		query *gorm.DB
+	}{
+		{&export.Bookmarks, db.Where("user_id = ?", user.ID)},
+		{&export.Orders, db.Preload("Transactions").Where("user_id = ?", user.ID).Order("created_at")},
+		{&export.Receipts, db.Where("user_id = ?", user.ID).Order("sequence")},
+		{&export.Subscriptions, db.Where("user_id = ?", user.ID).Order("created_at")},
+		{&export.Sessions, db.Where("user_id = ?", user.ID).Order("created_at")},
+		{&export.Identities, db.Where("user_id = ?", user.ID)},
+	}
+	if email := verifiedEmail(user); email != "" {
+		queries = append(queries, []struct {
+			dest  interface{}
+			query *gorm.DB
+		}{
+			{&export.TutorRequests, db.Where("LOWER(email) = ?", email)},
+			{&export.TutorApplications, db.Where("LOWER(email) = ?", email)},
+			{&export.TeacherJobProfiles, db.Where("LOWER(email) = ?", email)},
+			{&export.SchoolJobListings, db.Where("LOWER(contact_email) = ?", email)},
+			{&export.WebDevRequests, db.Where("LOWER(contact_email) = ?", email)},
+			{&export.Feedback, db.Where("LOWER(email) = ?", email)},
+		}...)
+	}
+
+	for _, q := range queries {
+		if err := q.query.Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+	return export, nil
+}
+
+/* resumeFile returns the path of an uploaded resume if it is inside resumeDir, so stored paths cannot point elsewhere */
+func resumeFile(path string) (string, bool) {
+	cleaned := filepath.Clean(path)
+	if path == "" || !strings.HasPrefix(cleaned, filepath.Clean(resumeDir)+string(filepath.Separator)) {
+		return "", false
+	}
+	return cleaned, true
+}
+
+/* resumes lists the uploaded resume files referenced by an export */
+func (e *userDataExport) resumes() []string {
+	var paths []string
+	for _, application := range e.TutorApplications {
+		if path, ok := resumeFile(application.ResumePath); ok {
+			paths = append(paths, path)
+		}
+	}
+	for _, profile := range e.TeacherJobProfiles {
+		if path, ok := resumeFile(profile.ResumePath); ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// ExportMyData returns a copy of everything stored about the logged-in user,
+// as required for access requests under the Data Protection Act. By default
+// the response is a ZIP archive with one JSON file per kind of record plus
+// any uploaded resumes; ?format=json returns a single JSON document instead.
+//
+// Query Parameters:
+//   - format (optional): "zip" (default) or "json".
+//
+// Responses:
+//   - 200 OK: The export.
+//   - 404 Not Found: The user no longer exists.
+//   - 500 Internal Server Error: The data could not be collected.
+func (uc *UsersController) ExportMyData(c *gin.Context) {
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	export, err := collectUserData(uc.DB, &user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not export data", "details": err.Error()})
+		return
+	}
+
+	filename := "cbcexams-data-" + export.ExportedAt.Format("20060102")
+	if c.Query("format") == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Status(http.StatusOK)
+
+	/* Stream the archive; once it has started, errors can only be logged */
+	archive := zip.NewWriter(c.Writer)
+	defer archive.Close()
+
+	files := map[string]interface{}{
+		"profile.json":              export.Profile,
+		"billing_profile.json":      export.BillingProfile,
+		"bookmarks.json":            export.Bookmarks,
+		"orders.json":               export.Orders,
+		"receipts.json":             export.Receipts,
+		"subscriptions.json":        export.Subscriptions,
+		"sessions.json":             export.Sessions,
+		"identities.json":           export.Identities,
+		"tutor_requests.json":       export.TutorRequests,
+		"tutor_applications.json":   export.TutorApplications,
+		"teacher_job_profiles.json": export.TeacherJobProfiles,
+		"school_job_listings.json":  export.SchoolJobListings,
+		"web_dev_requests.json":     export.WebDevRequests,
+		"feedback.json":             export.Feedback,
+	}
+	for name, data := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			log.Printf("Data export for %s failed: %v", user.ID, err)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			log.Printf("Data export for %s failed: %v", user.ID, err)
+			return
+		}
+	}
+
+	for _, path := range export.resumes() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Data export for %s skipped resume %s: %v", user.ID, path, err)
+			continue
+		}
+		w, err := archive.Create("resumes/" + filepath.Base(path))
+		if err != nil {
+			log.Printf("Data export for %s failed: %v", user.ID, err)
+			return
+		}
+		w.Write(content)
+	}
+}
+
+// DeleteMyAccount schedules the logged-in user's account for erasure after
+// the grace period (ACCOUNT_DELETION_GRACE_DAYS). All sessions are logged
+// out; logging in again before the date cancels the request. Payment records
+// are kept for tax purposes but detached from the user's contact details.
+//
+// Request Body:
+//   - confirm (required): Must be "DELETE".
+//   - password: The account password; required if one is set.
+//
+// Responses:
+//   - 202 Accepted: {"message": string, "deletion_scheduled_for": time}
+//   - 400 Bad Request: Missing confirmation.
+//   - 401 Unauthorized: Wrong password.
+//   - 404 Not Found: The user no longer exists.
+//   - 500 Internal Server Error: The request could not be saved.
+func (uc *UsersController) DeleteMyAccount(c *gin.Context) {
+	var input struct {
+		Confirm  string `json:"confirm" binding:"required"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.Confirm != "DELETE" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Send {"confirm": "DELETE"} to delete your account`})
+		return
+	}
+
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Password != "" {
+		if err := user.CheckPassword(input.Password); err != nil {
+			recordLoginFailure(uc.DB, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Incorrect password"})
+			return
+		}
+	}
+
+	scheduledFor := time.Now().In(config.EAT).Add(accountDeletionGracePeriod())
+	if err := uc.DB.Model(&user).UpdateColumn("deletion_scheduled_for", scheduledFor).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not schedule deletion", "details": err.Error()})
+		return
+	}
+
+	if err := revokeUserSessions(uc.DB, user.ID, nil); err != nil {
+		log.Printf("Failed to revoke sessions after deletion request for %s: %v", user.ID, err)
+	}
+
+	if user.Email != "" {
+		utils.SendAccountDeletionScheduledEmail(user.Email, scheduledFor)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":                "Your account will be deleted. Log in before the scheduled date to cancel.",
+		"deletion_scheduled_for": scheduledFor,
+	})
+}
+
+/* cancelAccountDeletion clears a pending deletion request when its owner logs in during the grace period */
+func cancelAccountDeletion(db *gorm.DB, user *models.User) {
+	if user.DeletionScheduledFor == nil {
+		return
+	}
+	if err := db.Model(user).UpdateColumn("deletion_scheduled_for", nil).Error; err != nil {
+		log.Printf("Failed to cancel deletion of %s: %v", user.ID, err)
+		return
+	}
+	user.DeletionScheduledFor = nil
+}
+
+// anonymizeUser erases a user's personal data. Records that only exist for
+// the user are deleted, along with forms sent from their verified email
+// address (see userDataExport); uploaded resumes are removed from disk,
+// orders keep their amounts but lose their contact details, and the user row
+// is kept with its identifying fields blanked so payment history still adds
+// up.
+func anonymizeUser(db *gorm.DB, user *models.User) error {
+	export, err := collectUserData(db, user)
+	if err != nil {
+		return err
+	}
+	resumes := export.resumes()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		byUser := []interface{}{
+			&models.Bookmark{}, &models.BillingProfile{}, &models.Session{},
+			&models.UserIdentity{}, &models.RecoveryCode{},
+		}
+		for _, model := range byUser {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if user.PhoneNumber != "" && user.PhoneVerifiedAt != nil {
+			if err := tx.Where("phone_number = ?", user.PhoneNumber).Delete(&models.PhoneOTP{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if email := verifiedEmail(user); email != "" {
+			byEmail := []struct {
+				model  interface{}
+				column string
+			}{
+				{&models.TutorRequest{}, "email"},
+				{&models.TutorApplication{}, "email"},
+				{&models.TeacherJobProfile{}, "email"},
+				{&models.SchoolJobListing{}, "contact_email"},
+				{&models.WebDevRequest{}, "contact_email"},
+				{&models.Feedback{}, "email"},
+			}
+			for _, m := range byEmail {
+				if err := tx.Where("LOWER("+m.column+") = ?", email).Delete(m.model).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Model(&models.Order{}).Where("user_id = ?", user.ID).
+			UpdateColumns(map[string]interface{}{"email": "", "phone_number": ""}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(user).UpdateColumns(map[string]interface{}{
+			"email":                  "",
+			"pending_email":          "",
+			"password":               "",
+			"first_name":             "",
+			"last_name":              "",
+			"phone_number":           "",
+			"phone_verified_at":      nil,
+			"email_verified_at":      nil,
+			"is_active":              false,
+			"totp_secret":            "",
+			"totp_enabled_at":        nil,
+			"password_reset_token":   "",
+			"deletion_scheduled_for": nil,
+			"anonymized_at":          time.Now().In(config.EAT),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	/* Files go only once the rows are gone, so a failed transaction leaves nothing dangling */
+	for _, path := range resumes {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove resume %s of %s: %v", path, user.ID, err)
+		}
+	}
+	return nil
+}
+
+// PurgeScheduledDeletions erases every account whose deletion grace period
+// has ended.
+//
+// Returns:
+//   - int: The number of accounts erased.
+//   - error: An error if the accounts could not be listed.
+func PurgeScheduledDeletions(db *gorm.DB) (int, error) {
+	var users []models.User
+	if err := db.Where("deletion_scheduled_for <= ? AND anonymized_at IS NULL", time.Now().In(config.EAT)).
+		Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range users {
+		if err := anonymizeUser(db, &users[i]); err != nil {
+			log.Printf("Failed to erase account %s: %v", users[i].ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunAccountPurger calls PurgeScheduledDeletions every interval until the
+// process exits. Start it in its own Goroutine.
+func RunAccountPurger(db *gorm.DB, interval time.Duration) {
+	for {
+		if purged, err := PurgeScheduledDeletions(db); err != nil {
+			log.Printf("Account purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("Erased %d account(s) after their deletion grace period", purged)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -1,81 +1,131 @@
 package controllers
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
 	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/bot-on-tapwater/cbcexams-backend/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type AuthController struct {
-	DB *gorm.DB
+	DB  *gorm.DB
+	SMS utils.SMSSender /* Sends login codes; messages are only logged when nil */
 }
 
 /* Register a new user */
 // Register handles the user registration process.
-// It binds the incoming JSON payload to a User model, hashes the user's password,
-// and saves the user to the database. If any step fails, it returns an appropriate
-// HTTP error response.
+// It binds the incoming JSON payload to a RegisterInput, hashes the user's password,
+// and saves the user to the database. The account stays inactive until the
+// user follows the verification link emailed to them. If any step fails, it
+// returns an appropriate HTTP error response.
 //
 // @param c *gin.Context - The Gin context containing the request and response objects.
 //
 // Possible Responses:
-// - 400 Bad Request: If the JSON payload is invalid.
+// - 400 Bad Request: If the JSON payload is invalid, the password does not meet
+//   the password policy or the role cannot be self-assigned.
 // - 409 Conflict: If the email already exists in the database.
 // - 500 Internal Server Error: If password hashing fails.
 // - 201 Created: If the user is successfully created.
 func (ac *AuthController) Register(c *gin.Context) {
 	log.Println("Register endpoint hit")
 
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var input models.RegisterInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	/* Only the fields in RegisterInput can be set by the client */
+	user := models.User{
+		Email:     input.Email,
+		Password:  input.Password,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Role:      input.Role,
+	}
+
+	/* Phone-only accounts sign up through /v1/auth/otp instead */
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required. To sign up with a phone number, use /v1/auth/otp/request."})
+		return
+	}
+
+	if input.PhoneNumber != "" {
+		phone, err := utils.NormalizeKenyanPhone(input.PhoneNumber)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.PhoneNumber = phone
+	}
+
+	/* Users pick any role except admin and tutor, which only an admin can grant */
+	if user.Role == "" {
+		user.Role = models.RoleStudent
+	}
+	if !models.IsSelfAssignableRole(user.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role", "allowed_roles": models.SelfAssignableRoles})
+		return
+	}
+
+	if err := utils.ValidatePassword(user.Password, user.Email, user.FirstName, user.LastName); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	fmt.Printf("Password before hashing: %s\n", user.Password)
 	if err := user.HashPassword(); err != nil {
-		log.Printf("Error binding JSON: %v", err)
+		log.Printf("Error hashing password: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not hash password"})
 		return
 	}
 
-	log.Printf("Payload: %+v", user)
-
 	if err := ac.DB.Create(&user).Error; err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User created"})
+	if err := sendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "User created. Check your email to verify your account."})
 }
 
 /* Login and return JWT */
 // Login handles user authentication by validating the provided email and password.
-// It expects a JSON payload with "email" and "password" fields, both of which are required.
-// If the credentials are valid, it generates a JWT token and returns it in the response.
+// It expects a JSON payload with "email" and "password" fields, both of which are required,
+// and an optional "device" name. If the credentials are valid, it starts a new session and
+// returns a short-lived access token and a refresh token. Repeated failures slow down and
+// then temporarily lock the account (see lockout.go). Accounts with two-factor
+// authentication get a two_factor_token instead, to exchange at VerifyTwoFactor.
 //
 // @Summary      User Login
-// @Description  Authenticates a user and returns a JWT token upon successful login.
+// @Description  Authenticates a user and returns an access token and refresh token upon successful login.
 // @Tags         Authentication
 // @Accept       json
 // @Produce      json
 // @Param        credentials  body  struct{Email string; Password string}  true  "User credentials"
-// @Success      200  {object}  map[string]string  "JWT token"
+// @Success      200  {object}  map[string]string  "Access and refresh tokens, or a two_factor_token"
 // @Failure      400  {object}  map[string]string  "Bad request error"
-// @Failure      404  {object}  map[string]string  "User not found error"
-// @Failure      401  {object}  map[string]string  "Invalid credentials error"
+// @Failure      401  {object}  map[string]string  "Invalid email or password (also for unknown and locked accounts)"
+// @Failure      403  {object}  map[string]string  "Email not verified error"
+// @Failure      429  {object}  map[string]string  "Too many failed attempts from this IP"
 // @Failure      500  {object}  map[string]string  "Internal server error"
 // @Router       /login [post]
 func (ac *AuthController) Login(c *gin.Context) {
 	var credentials struct {
 		Email    string `json:"email" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Device   string `json:"device"`
 	}
 
 	if err := c.ShouldBindJSON(&credentials); err != nil {
@@ -83,26 +133,55 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+	if ipLoginBlocked(ip) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts. Try again later."})
+		return
+	}
+
+	/*
+		Every failure gets the same response, a bcrypt comparison and a delay
+		counted per submitted email, so unknown and locked accounts cannot be
+		told apart from wrong passwords
+	*/
+	invalid := func() {
+		recordIPLoginFailure(ip)
+		time.Sleep(failureDelay(recordEmailLoginFailure(credentials.Email)))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+	}
+
 	var user models.User
 	if err := ac.DB.Where("email = ?", credentials.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(credentials.Password))
+		invalid()
 		return
 	}
 
-	if err := user.CheckPassword(credentials.Password); err != nil {
-		fmt.Println(credentials.Password)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	/* A locked account rejects even the right password; the owner was emailed */
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(credentials.Password))
+		invalid()
 		return
 	}
 
-	token, err := utils.GenerateJWT(user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
-
+	if err := user.CheckPassword(credentials.Password); err != nil {
+		recordLoginFailure(ac.DB, &user)
+		invalid()
+		return
+	}
+	resetLoginFailures(ac.DB, &user)
+	resetEmailLoginFailures(credentials.Email)
+
+	/* Only checked after the password so unverified emails are not revealed */
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Email address not verified. Check your inbox or request a new verification link.",
+			"code":  "email_not_verified",
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	beginLogin(c, ac.DB, &user, credentials.Device)
 }
 
 // ForgotPassword handles the process of initiating a password reset for a user.
@@ -147,8 +226,8 @@ func (ac *AuthController) ForgotPassword(c *gin.Context) {
 	token := utils.GenerateRandomToken(32)
 	expiresAt := time.Now().Add(1 * time.Hour)
 
-	/* Save token to DB */
-	user.PasswordResetToken = token
+	/* Save token to DB; only its hash is stored, like refresh tokens */
+	user.PasswordResetToken = utils.HashToken(token)
 	user.PasswordResetExpires = expiresAt
 	ac.DB.Save(&user)
 
@@ -169,12 +248,12 @@ func (ac *AuthController) ForgotPassword(c *gin.Context) {
 // The function performs the following steps:
 // 1. Validates the input JSON payload.
 // 2. Finds the user associated with the provided token, ensuring the token is valid and not expired.
-// 3. Updates the user's password after hashing it securely.
+// 3. Checks the new password against the password policy and updates it after hashing it securely.
 // 4. Clears the password reset token and its expiration date from the user's record.
 // 5. Responds with a success message if the operation is successful, or an error message otherwise.
 //
 // Possible HTTP responses:
-// - 400 Bad Request: If the input is invalid or the token is invalid/expired.
+// - 400 Bad Request: If the input is invalid, the token is invalid/expired or the password is too weak.
 // - 500 Internal Server Error: If hashing the password fails.
 // - 200 OK: If the password reset is successful.
 func (ac *AuthController) ResetPassword(c *gin.Context) {
@@ -192,7 +271,7 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 	var user models.User
 	err := ac.DB.Where(
 		"password_reset_token = ? AND password_reset_expires > ?",
-		input.Token,
+		utils.HashToken(input.Token),
 		time.Now(),
 	).First(&user).Error
 
@@ -201,6 +280,11 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidatePassword(input.NewPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	/* Update Password */
 	user.Password = input.NewPassword
 	if err := user.HashPassword(); err != nil {
@@ -213,20 +297,175 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 	user.PasswordResetExpires = time.Time{}
 	ac.DB.Save(&user)
 
+	/* Whoever knew the old password is logged out everywhere */
+	if err := revokeUserSessions(ac.DB, user.ID, nil); err != nil {
+		log.Printf("Failed to revoke sessions after password reset for %s: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Password reset successful"})
 }
 
+// VerifyEmail activates the account whose email address the token was sent to,
+// or, for a link sent to a pending email address, makes that address the
+// account's email. It expects a JSON payload with the "token" from the
+// verification link. Verifying an already verified account succeeds without
+// changes.
+//
+// Possible Responses:
+// - 200 OK: The email address is verified.
+// - 400 Bad Request: The token is missing, invalid, expired or for an old email address.
+// - 409 Conflict: Another account has taken the pending email address since it was requested.
+func (ac *AuthController) VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, email, err := utils.ParseEmailVerificationToken(input.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid/expired token"})
+		return
+	}
+
+	/* The token only counts for the address it was sent to */
+	var user models.User
+	if err := ac.DB.First(&user, "id = ? AND (email = ? OR pending_email = ?)", userID, email, email).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid/expired token"})
+		return
+	}
+
+	if user.PendingEmail == email && user.Email != email {
+		var taken int64
+		if err := ac.DB.Model(&models.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&taken).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "This email address is already in use"})
+			return
+		}
+
+		now := time.Now().In(config.EAT)
+		if err := ac.DB.Model(&user).Updates(map[string]interface{}{
+			"email":             email,
+			"pending_email":     "",
+			"is_active":         true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email address changed."})
+		return
+	}
+
+	if !user.IsActive {
+		now := time.Now().In(config.EAT)
+		if err := ac.DB.Model(&user).Updates(map[string]interface{}{"is_active": true, "email_verified_at": now}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified. You can now log in."})
+}
+
+/* Throttles verification emails to one per address per resendVerificationInterval */
+var verificationThrottle = cache.New(resendVerificationInterval, 10*time.Minute)
+
+const resendVerificationInterval = time.Minute
+
+// ResendVerification emails a new verification link to an unverified account.
+// It expects a JSON payload with the "email" field. Like ForgotPassword, it
+// responds the same way whether or not the address is registered.
+//
+// Possible Responses:
+// - 200 OK: A link was sent if the account exists and is unverified.
+// - 400 Bad Request: The email is missing or malformed.
+// - 429 Too Many Requests: A link was sent to this address less than a minute ago.
+func (ac *AuthController) ResendVerification(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+		return
+	}
+
+	if err := verificationThrottle.Add(input.Email, true, cache.DefaultExpiration); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Please wait a minute before requesting another link"})
+		return
+	}
+
+	var user models.User
+	if err := ac.DB.Where("email = ?", input.Email).First(&user).Error; err == nil && !user.IsActive {
+		if err := sendVerificationEmail(&user); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists and is unverified, a verification link has been sent"})
+}
+
+/* sendVerificationEmail emails a fresh verification link to the user's current address */
+func sendVerificationEmail(user *models.User) error {
+	token, err := utils.GenerateEmailVerificationToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+	return utils.SendVerificationEmail(user.Email, token)
+}
+
+/* sendEmailChangeEmail emails a link confirming the user's pending email address */
+func sendEmailChangeEmail(user *models.User) error {
+	token, err := utils.GenerateEmailVerificationToken(user.ID, user.PendingEmail)
+	if err != nil {
+		return err
+	}
+	return utils.SendEmailChangeEmail(user.PendingEmail, token)
+}
+
 // Logout handles the user logout process.
-// Since JWT is stateless, this function assumes that client-side token deletion
-// is sufficient for logging out. If additional security is required, such as
-// invalidating tokens server-side, a token blacklist mechanism can be implemented.
+// It revokes the session the access token belongs to, so both the access token
+// and its refresh token stop working immediately.
 // Responds with a success message upon logout.
 func (ac *AuthController) Logout(c *gin.Context) {
-	/* JWT is stateless, so client-side token deletion is sufficient. */
-	/* TODO:Implement a token blacklist if needed */
+	sessionID, err := uuid.Parse(c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := ac.DB.Model(&models.Session{}).Where("id = ?", sessionID).
+		Update("revoked_at", time.Now().In(config.EAT)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
+// LogoutAll logs the user out of every device, including the one making the
+// request.
+func (ac *AuthController) LogoutAll(c *gin.Context) {
+	userID, ok := utils.ParseUserIDFromString(c, c.GetString("user_id"))
+	if !ok {
+		return
+	}
+
+	if err := revokeUserSessions(ac.DB, userID, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all devices"})
+}
+
 // CheckAuth handles the authentication check for a user.
 //
 // This method is invoked after the JWTAuth middleware has validated the user's token.
@@ -242,7 +481,7 @@ func (ac *AuthController) Logout(c *gin.Context) {
 //   - If the user ID cannot be parsed into a valid UUID, it terminates the request.
 //   - If the user is not found in the database, it responds with HTTP 404 Not Found.
 //   - If the user is authenticated and exists, it responds with HTTP 200 OK and includes
-//     the user's email, first name, and last name in the response.
+//     the user's email, first name, last name and role in the response.
 //
 // Response:
 //   - HTTP 200 OK: Returns a JSON object with `isAuthenticated` set to true and user details.
@@ -264,7 +503,7 @@ func (ac *AuthController) CheckAuth(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := ac.DB.Select("id, email, first_name, last_name").First(&user, "id = ?", parsedUserID).Error; err != nil {
+	if err := ac.DB.Select("id, email, first_name, last_name, role").First(&user, "id = ?", parsedUserID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -275,6 +514,7 @@ func (ac *AuthController) CheckAuth(c *gin.Context) {
 			"email":     user.Email,
 			"firstName": user.FirstName,
 			"lastName":  user.LastName,
+			"role":      user.Role,
 		},
 	})
 }
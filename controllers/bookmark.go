@@ -60,6 +60,11 @@ func (bc *BookmarkController) CreateBookmark(c *gin.Context) {
 		return
 	}
 
+	/* Download links are for subscribers only */
+	if !hasActiveSubscription(c) {
+		resource.GoogleCloudStorageLink = ""
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Resource bookmarked successfully",
 		"data": gin.H{
@@ -116,6 +121,10 @@ func (bc *BookmarkController) GetUserBookmarks(c *gin.Context) {
 	/* Extract resources from bookmarks */
 	var resources []models.WebCrawlerResource
 	for _, b := range bookmarks {
+		/* Download links are for subscribers only */
+		if !hasActiveSubscription(c) {
+			b.Resource.GoogleCloudStorageLink = ""
+		}
 		resources = append(resources, b.Resource)
 	}
 
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/* resourceFacet is a dimension resources can be filtered and counted by */
+type resourceFacet struct {
+	param  string /* Query parameter and key in "facets" */
+	column string
+}
+
+/* Facets in the order they are returned */
+var resourceFacets = []resourceFacet{
+	{param: "level", column: "level"},
+	{param: "subject", column: "subject"},
+	{param: "type", column: "resource_type"},
+	{param: "term", column: "term"},
+	{param: "year", column: "year"},
+}
+
+/* facetCount is how many matching resources have one value of a facet */
+type facetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+/* resourceFilters are the facet values a listing is narrowed to; empty and 0 mean any */
+type resourceFilters struct {
+	Level   string
+	Subject string
+	Type    string
+	Term    int
+	Year    int
+}
+
+// resourceFiltersFromQuery reads the level, subject, type, term and year
+// query parameters. Labels are run through utils.ClassifyResource so
+// "form2", "maths" or "ms" filter by "Form 2", "Mathematics" and "Marking
+// Scheme"; anything it does not recognise is matched as given.
+//
+// Returns:
+//   - resourceFilters: The filters to apply.
+//   - error: An error if term or year is not a number.
+func resourceFiltersFromQuery(c *gin.Context) (resourceFilters, error) {
+	var filters resourceFilters
+
+	if level := strings.TrimSpace(c.Query("level")); level != "" {
+		filters.Level = canonicalLabel(level, utils.ClassifyResource(level, "").Level)
+	}
+	if subject := strings.TrimSpace(c.Query("subject")); subject != "" {
+		filters.Subject = canonicalLabel(subject, utils.ClassifyResource(subject, "").Subject)
+	}
+	if resourceType := strings.TrimSpace(c.Query("type")); resourceType != "" {
+		filters.Type = canonicalLabel(resourceType, utils.ClassifyResource(resourceType, "").Type)
+	}
+
+	for _, number := range []struct {
+		param string
+		dest  *int
+	}{{"term", &filters.Term}, {"year", &filters.Year}} {
+		value := strings.TrimSpace(c.Query(number.param))
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return filters, fmt.Errorf("%s must be a number", number.param)
+		}
+		*number.dest = parsed
+	}
+
+	return filters, nil
+}
+
+/* canonicalLabel prefers the classifier's label for a filter value, falling back to the value itself */
+func canonicalLabel(value, classified string) string {
+	if classified != "" {
+		return classified
+	}
+	return value
+}
+
+/* scope narrows a resource query to the filters */
+func (f resourceFilters) scope(db *gorm.DB) *gorm.DB {
+	if f.Level != "" {
+		db = db.Where("level = ?", f.Level)
+	}
+	if f.Subject != "" {
+		db = db.Where("subject = ?", f.Subject)
+	}
+	if f.Type != "" {
+		db = db.Where("resource_type = ?", f.Type)
+	}
+	if f.Term != 0 {
+		db = db.Where("term = ?", f.Term)
+	}
+	if f.Year != 0 {
+		db = db.Where("year = ?", f.Year)
+	}
+	return db
+}
+
+/* cacheKey identifies the filters in a resource listing's cache key */
+func (f resourceFilters) cacheKey() string {
+	return fmt.Sprintf("level=%s&subject=%s&type=%s&term=%d&year=%d", f.Level, f.Subject, f.Type, f.Term, f.Year)
+}
+
+/* applied lists the filters in use, keyed by query parameter */
+func (f resourceFilters) applied() gin.H {
+	applied := gin.H{}
+	if f.Level != "" {
+		applied["level"] = f.Level
+	}
+	if f.Subject != "" {
+		applied["subject"] = f.Subject
+	}
+	if f.Type != "" {
+		applied["type"] = f.Type
+	}
+	if f.Term != 0 {
+		applied["term"] = f.Term
+	}
+	if f.Year != 0 {
+		applied["year"] = f.Year
+	}
+	return applied
+}
+
+// countResourceFacets counts the resources matched by a query for each value
+// of every facet, most common first. Resources the classifier could not label
+// for a facet are left out of its counts.
+//
+// Parameters:
+//   - query: The unordered, unpaginated query; it is not modified.
+//
+// Returns:
+//   - gin.H: Facet name → []facetCount.
+//   - error: An error if a count failed.
+func countResourceFacets(query *gorm.DB) (gin.H, error) {
+	base := query.Session(&gorm.Session{})
+	facets := gin.H{}
+
+	for _, facet := range resourceFacets {
+		counts := []facetCount{}
+		if err := base.Select(facet.column + "::text AS value, COUNT(*) AS count").
+			Where(facet.column + "::text NOT IN ('', '0')").
+			Group(facet.column).
+			Order("count DESC, value").
+			Scan(&counts).Error; err != nil {
+			return nil, err
+		}
+		facets[facet.param] = counts
+	}
+
+	return facets, nil
+}
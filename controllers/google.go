@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/oidc"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/* Cookie holding the signed state of a sign-in in progress */
+const oidcStateCookie = "oidc_state"
+
+type GoogleAuthController struct {
+	DB       *gorm.DB
+	Provider *oidc.Provider
+}
+
+// NewGoogleAuthController configures Google sign-in from the environment.
+//
+// Environment Variables:
+//   - GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET: OAuth client credentials.
+//   - GOOGLE_REDIRECT_URL: This API's /v1/auth/google/callback URL.
+//   - GOOGLE_ISSUER (optional): Defaults to https://accounts.google.com; point
+//     it at cmd/mock-oidc to sign in offline.
+func NewGoogleAuthController(db *gorm.DB) *GoogleAuthController {
+	issuer := os.Getenv("GOOGLE_ISSUER")
+	if issuer == "" {
+		issuer = "https://accounts.google.com"
+	}
+
+	return &GoogleAuthController{
+		DB: db,
+		Provider: &oidc.Provider{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
+		},
+	}
+}
+
+// Start begins a Google sign-in. It remembers a random state and nonce in a
+// short-lived cookie and redirects the browser to Google.
+//
+// Query Parameters:
+//   - mode (optional): "json" returns {"url": ...} instead of redirecting,
+//     for clients that open the sign-in page themselves.
+//
+// Responses:
+//   - 302 Found: Redirect to Google.
+//   - 200 OK: {"url": ...} when mode=json.
+//   - 503 Service Unavailable: Google sign-in is not configured.
+//   - 502 Bad Gateway: Google's configuration could not be fetched.
+func (gc *GoogleAuthController) Start(c *gin.Context) {
+	if !gc.Provider.Configured() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Google sign-in is not configured"})
+		return
+	}
+
+	state := utils.GenerateRandomToken(16)
+	nonce := utils.GenerateRandomToken(16)
+
+	authURL, err := gc.Provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Google sign-in is unavailable", "details": err.Error()})
+		return
+	}
+
+	stateToken, err := utils.GenerateOIDCStateToken(state, nonce)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start sign-in"})
+		return
+	}
+
+	/* Lax so the cookie survives the top-level redirect back from Google */
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, stateToken, int((10 * time.Minute).Seconds()), "/v1/auth/google", "", c.Request.TLS != nil, true)
+
+	if c.Query("mode") == "json" {
+		c.JSON(http.StatusOK, gin.H{"url": authURL})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes a Google sign-in. It checks the state against the cookie
+// set by Start, exchanges the code, verifies the ID token and logs in the user
+// linked to the Google account, linking or creating one by verified email if
+// needed. The response is the same as Login's, including the two-factor step.
+//
+// When GOOGLE_LOGIN_REDIRECT_URL is set the browser is redirected there with
+// the tokens (or an error) in the URL fragment; otherwise they are returned
+// as JSON.
+//
+// Responses:
+//   - 200 OK: Access and refresh tokens.
+//   - 400 Bad Request: Missing or mismatched state, or the user cancelled.
+//   - 401 Unauthorized: The ID token failed verification.
+//   - 403 Forbidden: Google has not verified the account's email address.
+//   - 502 Bad Gateway: The code could not be exchanged.
+func (gc *GoogleAuthController) Callback(c *gin.Context) {
+	if errParam := c.Query("error"); errParam != "" {
+		gc.fail(c, http.StatusBadRequest, "Google sign-in was cancelled: "+errParam)
+		return
+	}
+
+	stateCookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		gc.fail(c, http.StatusBadRequest, "Sign-in session expired, please try again")
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/v1/auth/google", "", c.Request.TLS != nil, true)
+
+	state, nonce, err := utils.ParseOIDCStateToken(stateCookie)
+	if err != nil || state != c.Query("state") {
+		gc.fail(c, http.StatusBadRequest, "Invalid sign-in state, please try again")
+		return
+	}
+
+	tokens, err := gc.Provider.Exchange(c.Query("code"))
+	if err != nil {
+		log.Printf("Google code exchange failed: %v", err)
+		gc.fail(c, http.StatusBadGateway, "Could not complete Google sign-in")
+		return
+	}
+
+	claims, err := gc.Provider.VerifyIDToken(tokens.IDToken, nonce)
+	if err != nil {
+		log.Printf("Google ID token rejected: %v", err)
+		gc.fail(c, http.StatusUnauthorized, "Could not verify Google sign-in")
+		return
+	}
+
+	user, err := gc.findOrCreateUser(claims)
+	if errors.Is(err, errUnverifiedEmail) {
+		gc.fail(c, http.StatusForbidden, "Your Google account's email address is not verified")
+		return
+	}
+	if err != nil {
+		log.Printf("Google sign-in failed for %s: %v", claims.Email, err)
+		gc.fail(c, http.StatusInternalServerError, "Could not complete Google sign-in")
+		return
+	}
+
+	/* Accounts with two-factor authentication still need their code */
+	result, err := twoFactorChallenge(user, "Google sign-in")
+	if err == nil && result == nil {
+		result, err = issueSession(gc.DB, c, user, "Google sign-in")
+	}
+	if err != nil {
+		gc.fail(c, http.StatusInternalServerError, "Could not generate token")
+		return
+	}
+
+	if redirect := os.Getenv("GOOGLE_LOGIN_REDIRECT_URL"); redirect != "" {
+		fragment := url.Values{}
+		for _, key := range []string{"access_token", "refresh_token", "expires_in", "role", "two_factor_required", "two_factor_token"} {
+			if value, ok := result[key]; ok {
+				fragment.Set(key, fmt.Sprint(value))
+			}
+		}
+		c.Redirect(http.StatusFound, redirect+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+var errUnverifiedEmail = errors.New("email address not verified by the provider")
+
+// findOrCreateUser returns the user linked to a Google account. An unknown
+// Google account is linked to the verified user with the same email address,
+// or a new user is created, provided Google has verified the address.
+//
+// An unverified account with the address is never handed over: whoever
+// registered it may not own the address and could still hold a session,
+// phone number or second factor on it. It gives up the address, its sessions
+// are revoked, and the Google user gets a new account.
+func (gc *GoogleAuthController) findOrCreateUser(claims *oidc.Claims) (*models.User, error) {
+	var user models.User
+	now := time.Now().In(config.EAT)
+
+	err := gc.DB.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", models.IdentityProviderGoogle, claims.Subject).First(&identity).Error
+		if err == nil {
+			tx.Model(&identity).Updates(map[string]interface{}{"last_login_at": now, "email": claims.Email})
+			return tx.First(&user, "id = ?", identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if !claims.EmailVerified || claims.Email == "" {
+			return errUnverifiedEmail
+		}
+
+		err = tx.Where("LOWER(email) = LOWER(?)", claims.Email).First(&user).Error
+		found := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if found && !user.IsActive {
+			log.Printf("Releasing %s from unverified account %s for a Google sign-in", claims.Email, user.ID)
+			if err := tx.Model(&user).Update("email", "").Error; err != nil {
+				return err
+			}
+			if err := revokeUserSessions(tx, user.ID, nil); err != nil {
+				return err
+			}
+		}
+
+		if !found || !user.IsActive {
+			user = models.User{
+				Email:           claims.Email,
+				FirstName:       claims.GivenName,
+				LastName:        claims.FamilyName,
+				Role:            models.RoleStudent,
+				IsActive:        true,
+				EmailVerifiedAt: &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:      user.ID,
+			Provider:    models.IdentityProviderGoogle,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: now,
+		}).Error
+	})
+
+	return &user, err
+}
+
+/* fail reports a sign-in error as JSON, or to the frontend when GOOGLE_LOGIN_REDIRECT_URL is set */
+func (gc *GoogleAuthController) fail(c *gin.Context, status int, message string) {
+	if redirect := os.Getenv("GOOGLE_LOGIN_REDIRECT_URL"); redirect != "" {
+		c.Redirect(http.StatusFound, redirect+"#"+url.Values{"error": {message}}.Encode())
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
+// GetIdentities lists the external accounts linked to the logged-in user and
+// whether they also have a password.
+func (gc *GoogleAuthController) GetIdentities(c *gin.Context) {
+	userID, ok := utils.ParseUserIDFromString(c, c.GetString("user_id"))
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := gc.DB.Select("id", "password").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var identities []models.UserIdentity
+	if err := gc.DB.Where("user_id = ?", userID).Find(&identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"has_password": user.Password != "", "data": identities})
+}
+
+// UnlinkIdentity removes an external account from the logged-in user. Users
+// without a password cannot remove their last identity, or they could no
+// longer sign in.
+//
+// Responses:
+//   - 200 OK: The identity was unlinked.
+//   - 400 Bad Request: It is the user's only way to sign in.
+//   - 404 Not Found: No identity for this provider is linked.
+func (gc *GoogleAuthController) UnlinkIdentity(c *gin.Context) {
+	userID, ok := utils.ParseUserIDFromString(c, c.GetString("user_id"))
+	if !ok {
+		return
+	}
+	provider := strings.ToLower(c.Param("provider"))
+
+	var user models.User
+	if err := gc.DB.Select("id", "password").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var count int64
+	gc.DB.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count)
+	if user.Password == "" && count <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set a password before removing your only sign-in method"})
+		return
+	}
+
+	result := gc.DB.Where("user_id = ? AND provider = ?", userID, provider).Delete(&models.UserIdentity{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/oidc"
+	"github.com/google/uuid"
+)
+
+func TestGoogleSignInDoesNotTakeOverUnverifiedAccount(t *testing.T) {
+	db := testDB(t)
+
+	/* Someone registered the address without verifying it and is still logged in */
+	squatter := createTestUser(t, db)
+	db.Model(squatter).Updates(map[string]interface{}{"is_active": false, "email_verified_at": nil, "password": "$2a$10$hash"})
+	session := createTestSession(t, db, squatter.ID)
+
+	gc := &GoogleAuthController{DB: db}
+	user, err := gc.findOrCreateUser(&oidc.Claims{Subject: uuid.NewString(), Email: squatter.Email, EmailVerified: true, GivenName: "Jane"})
+	if err != nil {
+		t.Fatalf("findOrCreateUser: %v", err)
+	}
+
+	if user.ID == squatter.ID {
+		t.Fatal("the Google user was given the unverified account")
+	}
+	if user.Email != squatter.Email || !user.IsActive || user.EmailVerifiedAt == nil || user.Password != "" {
+		t.Errorf("new account = %+v, want a verified account with the Google address and no password", user.Response())
+	}
+	assertSessionRevoked(t, db, session)
+
+	var released models.User
+	db.First(&released, "id = ?", squatter.ID)
+	if released.Email != "" {
+		t.Errorf("the unverified account kept the address %q", released.Email)
+	}
+
+	var identity models.UserIdentity
+	if err := db.First(&identity, "user_id = ?", user.ID).Error; err != nil {
+		t.Errorf("the Google identity is not linked to the new account: %v", err)
+	}
+}
+
+func TestGoogleSignInLinksVerifiedAccount(t *testing.T) {
+	db := testDB(t)
+	existing := createTestUser(t, db)
+	session := createTestSession(t, db, existing.ID)
+
+	gc := &GoogleAuthController{DB: db}
+	user, err := gc.findOrCreateUser(&oidc.Claims{Subject: uuid.NewString(), Email: existing.Email, EmailVerified: true})
+	if err != nil {
+		t.Fatalf("findOrCreateUser: %v", err)
+	}
+	if user.ID != existing.ID {
+		t.Error("a verified account with the address was not linked")
+	}
+
+	db.First(session, "id = ?", session.ID)
+	if session.RevokedAt != nil {
+		t.Error("linking Google to a verified account revoked its sessions")
+	}
+}
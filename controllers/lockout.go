@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+/* Login throttling settings */
+const (
+	lockoutThreshold   = 5                /* Consecutive failures that lock an account */
+	lockoutBase        = 15 * time.Minute /* First lockout; doubles with every further threshold reached */
+	lockoutMax         = 24 * time.Hour
+	maxFailureDelay    = 4 * time.Second
+	ipFailureWindow    = 15 * time.Minute
+	ipFailureThreshold = 20 /* Failed logins per IP per window before the IP is refused */
+	emailFailureWindow = time.Hour
+)
+
+/* Failed login counts per client IP, forgotten after ipFailureWindow */
+var loginIPFailures = cache.New(ipFailureWindow, 5*time.Minute)
+
+/*
+Failed login counts per submitted email address, forgotten after
+emailFailureWindow. They set the failure delay, and are kept whether or not
+an account has the address so the delay does not reveal which ones do.
+*/
+var loginEmailFailures = cache.New(emailFailureWindow, 10*time.Minute)
+
+/*
+dummyPasswordHash is compared against when the email is unknown or the
+account is locked, so those take as long to reject as a wrong password.
+*/
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+/* ipLoginBlocked reports whether the client IP has failed too many logins recently */
+func ipLoginBlocked(ip string) bool {
+	failures, found := loginIPFailures.Get(ip)
+	return found && failures.(int) >= ipFailureThreshold
+}
+
+/* recordIPLoginFailure counts a failed login from ip */
+func recordIPLoginFailure(ip string) {
+	if _, err := loginIPFailures.IncrementInt(ip, 1); err != nil {
+		loginIPFailures.Set(ip, 1, cache.DefaultExpiration)
+	}
+}
+
+/* recordEmailLoginFailure counts a failed login for an email address and returns the count in the window */
+func recordEmailLoginFailure(email string) int {
+	key := strings.ToLower(strings.TrimSpace(email))
+	failures, err := loginEmailFailures.IncrementInt(key, 1)
+	if err != nil {
+		loginEmailFailures.Set(key, 1, cache.DefaultExpiration)
+		return 1
+	}
+	return failures
+}
+
+/* resetEmailLoginFailures forgets an email address's failures after a successful login */
+func resetEmailLoginFailures(email string) {
+	loginEmailFailures.Delete(strings.ToLower(strings.TrimSpace(email)))
+}
+
+/* failureDelay returns how long to hold a failed login's response: 0, 0, 1s, 2s, 4s, 4s, ... */
+func failureDelay(failures int) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+	delay := time.Second << (failures - 3)
+	if delay > maxFailureDelay || delay <= 0 {
+		return maxFailureDelay
+	}
+	return delay
+}
+
+/* lockoutDuration returns how long an account with the given consecutive failures is locked, if at all */
+func lockoutDuration(failures int) time.Duration {
+	if failures < lockoutThreshold || failures%lockoutThreshold != 0 {
+		return 0
+	}
+	duration := lockoutBase << (failures/lockoutThreshold - 1)
+	if duration > lockoutMax || duration <= 0 {
+		return lockoutMax
+	}
+	return duration
+}
+
+// recordLoginFailure counts a wrong password against an account and locks it
+// when the count reaches a multiple of lockoutThreshold, emailing the owner.
+//
+// Returns:
+//   - int: The account's consecutive failed attempts, including this one.
+func recordLoginFailure(db *gorm.DB, user *models.User) int {
+	if err := db.Model(user).UpdateColumn("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error; err != nil {
+		log.Printf("Failed to record login failure for %s: %v", user.ID, err)
+		return user.FailedLoginAttempts + 1
+	}
+	db.Select("id", "failed_login_attempts").First(user, "id = ?", user.ID)
+
+	if duration := lockoutDuration(user.FailedLoginAttempts); duration > 0 {
+		lockedUntil := time.Now().In(config.EAT).Add(duration)
+		if err := db.Model(user).UpdateColumn("locked_until", lockedUntil).Error; err != nil {
+			log.Printf("Failed to lock account %s: %v", user.ID, err)
+		} else if user.Email != "" {
+			utils.SendAccountLockedEmail(user.Email, lockedUntil)
+		}
+	}
+
+	return user.FailedLoginAttempts
+}
+
+/* resetLoginFailures clears the failure count after a successful login */
+func resetLoginFailures(db *gorm.DB, user *models.User) {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return
+	}
+	db.Model(user).UpdateColumns(map[string]interface{}{"failed_login_attempts": 0, "locked_until": nil})
+}
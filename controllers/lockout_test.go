@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailLoginFailuresSetTheDelay(t *testing.T) {
+	const email = "nobody@example.com"
+	t.Cleanup(func() { resetEmailLoginFailures(email) })
+
+	/* The count is per address, however it is typed, and does not depend on an account existing */
+	var delays []time.Duration
+	for _, typed := range []string{email, "Nobody@Example.com", " nobody@example.com", email, email} {
+		delays = append(delays, failureDelay(recordEmailLoginFailure(typed)))
+	}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("failure %d delayed %v, want %v", i+1, delays[i], want[i])
+		}
+	}
+
+	resetEmailLoginFailures(email)
+	if got := failureDelay(recordEmailLoginFailure(email)); got != 0 {
+		t.Errorf("first failure after a successful login delayed %v, want 0", got)
+	}
+}
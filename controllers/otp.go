@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/* One-time code settings */
+const (
+	otpLength         = 6
+	otpTTL            = 5 * time.Minute
+	otpMaxAttempts    = 5
+	otpResendInterval = time.Minute
+	otpHourlyLimit    = 5
+	otpIPHourlyLimit  = 20 /* Codes per client IP per hour, across all phone numbers */
+)
+
+/* Codes sent per client IP, forgotten an hour after the first */
+var otpIPRequests = cache.New(time.Hour, 10*time.Minute)
+
+/* smsSender returns the configured SMS sender, logging messages when none is set */
+func (ac *AuthController) smsSender() utils.SMSSender {
+	if ac.SMS == nil {
+		return utils.LogSMSSender{}
+	}
+	return ac.SMS
+}
+
+/* generateOTP returns a random numeric code of otpLength digits */
+func generateOTP() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(otpLength), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpLength, n), nil
+}
+
+/* hashOTP binds a code to the phone number it was sent to before hashing it */
+func hashOTP(phone, code string) string {
+	return utils.HashToken(phone + ":" + code)
+}
+
+// RequestOTP sends a one-time login code by SMS. It works for registered and
+// new phone numbers alike; the account is created when the code is verified.
+// Requesting a new code invalidates any earlier one.
+//
+// Request Body:
+//   - phone_number (required): A Kenyan mobile number in any common format.
+//
+// Possible Responses:
+// - 200 OK: The code was sent.
+// - 400 Bad Request: The phone number is not a valid Kenyan mobile number.
+// - 429 Too Many Requests: A code was sent less than a minute ago, or too many this hour to the number or IP.
+// - 500 Internal Server Error: The code could not be stored.
+func (ac *AuthController) RequestOTP(c *gin.Context) {
+	var input struct {
+		PhoneNumber string `json:"phone_number" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone, err := utils.NormalizeKenyanPhone(input.PhoneNumber)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	/* Throttle per IP so one client cannot send codes to many numbers */
+	ip := c.ClientIP()
+	if sent, found := otpIPRequests.Get(ip); found && sent.(int) >= otpIPHourlyLimit {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many codes requested. Try again later."})
+		return
+	}
+
+	now := time.Now().In(config.EAT)
+
+	/* Throttle resends per phone number */
+	var latest models.PhoneOTP
+	if err := ac.DB.Where("phone_number = ?", phone).Order("created_at DESC").First(&latest).Error; err == nil {
+		if wait := otpResendInterval - now.Sub(latest.CreatedAt); wait > 0 {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Please wait before requesting another code", "retry_after": int(wait.Seconds()) + 1})
+			return
+		}
+	}
+
+	var sentLastHour int64
+	ac.DB.Model(&models.PhoneOTP{}).Where("phone_number = ? AND created_at > ?", phone, now.Add(-time.Hour)).Count(&sentLastHour)
+	if sentLastHour >= otpHourlyLimit {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many codes requested. Try again later."})
+		return
+	}
+
+	code, err := generateOTP()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate code"})
+		return
+	}
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PhoneOTP{}).
+			Where("phone_number = ? AND consumed_at IS NULL", phone).
+			Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PhoneOTP{
+			PhoneNumber: phone,
+			CodeHash:    hashOTP(phone, code),
+			ExpiresAt:   now.Add(otpTTL),
+			IPAddress:   ip,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not store code"})
+		return
+	}
+
+	if _, err := otpIPRequests.IncrementInt(ip, 1); err != nil {
+		otpIPRequests.Set(ip, 1, cache.DefaultExpiration)
+	}
+
+	/* Send in the background like emails; the user can request another code if it never arrives */
+	message := fmt.Sprintf("Your CBC Exams code is %s. It expires in %d minutes. Do not share it.", code, int(otpTTL.Minutes()))
+	go func() {
+		if err := ac.smsSender().Send(phone, message); err != nil {
+			log.Printf("Failed to send OTP to %s: %v", phone, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Code sent", "phone_number": phone, "expires_in": int(otpTTL.Seconds())})
+}
+
+var errOTPInvalid = errors.New("invalid or expired code")
+
+// VerifyOTP logs in with a code sent by RequestOTP. Only an account that has
+// already verified the phone number is logged into. A phone number without
+// such an account gets a new one, using the optional profile fields; if the
+// number sits unverified on another account's profile, it is removed from
+// there first, since the code proves it belongs to the caller. The response
+// is the same as Login's, including the two-factor step.
+//
+// Request Body:
+//   - phone_number (required): The number the code was sent to.
+//   - code (required): The code from the SMS.
+//   - first_name, last_name (optional): Used when creating a new account.
+//   - role (optional): Role of a new account; defaults to parent.
+//   - device (optional): Device name for the session list.
+//
+// Possible Responses:
+// - 200 OK: Access and refresh tokens.
+// - 400 Bad Request: Invalid phone number, role, or wrong/expired code.
+// - 429 Too Many Requests: Too many wrong guesses; request a new code.
+func (ac *AuthController) VerifyOTP(c *gin.Context) {
+	var input struct {
+		PhoneNumber string `json:"phone_number" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+		FirstName   string `json:"first_name"`
+		LastName    string `json:"last_name"`
+		Role        string `json:"role"`
+		Device      string `json:"device"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone, err := utils.NormalizeKenyanPhone(input.PhoneNumber)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = models.RoleParent
+	}
+	if !models.IsSelfAssignableRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role", "allowed_roles": models.SelfAssignableRoles})
+		return
+	}
+
+	now := time.Now().In(config.EAT)
+	var user models.User
+	wrongGuess := false
+	attemptsLeft := 0
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		var otp models.PhoneOTP
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("phone_number = ? AND consumed_at IS NULL", phone).
+			Order("created_at DESC").First(&otp).Error; err != nil {
+			return errOTPInvalid
+		}
+		if !otp.IsUsable(now, otpMaxAttempts) {
+			return errOTPInvalid
+		}
+
+		/* Wrong guesses are committed, not rolled back, so they count */
+		if subtle.ConstantTimeCompare([]byte(otp.CodeHash), []byte(hashOTP(phone, input.Code))) != 1 {
+			wrongGuess = true
+			attemptsLeft = otpMaxAttempts - otp.Attempts - 1
+			return tx.Model(&otp).Update("attempts", gorm.Expr("attempts + 1")).Error
+		}
+
+		if err := tx.Model(&otp).Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+
+		/* The code proves the phone number, so find or create its account */
+		err := tx.Where("phone_number = ?", phone).First(&user).Error
+		switch {
+		case err == nil && user.PhoneVerifiedAt != nil:
+			return nil
+		case err == nil:
+			/* Anyone can type a number into their profile; it never logs into that account */
+			log.Printf("Removing unverified phone number from account %s after it was verified by another caller", user.ID)
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("phone_number", "").Error; err != nil {
+				return err
+			}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		user = models.User{
+			PhoneNumber:     phone,
+			PhoneVerifiedAt: &now,
+			FirstName:       input.FirstName,
+			LastName:        input.LastName,
+			Role:            input.Role,
+			IsActive:        true,
+		}
+		return tx.Create(&user).Error
+	})
+
+	if err != nil && !errors.Is(err, errOTPInvalid) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify code"})
+		return
+	}
+	if wrongGuess && attemptsLeft <= 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong codes. Request a new code."})
+		return
+	}
+	if wrongGuess {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code", "attempts_remaining": attemptsLeft})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	beginLogin(c, ac.DB, &user, input.Device)
+}
@@ -2,83 +2,214 @@ package controllers
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
-	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PaymentController struct {
-	PesaPalConfig *pesapal.Config
+	DB      *gorm.DB
+	PesaPal *pesapal.Client
 }
 
-func NewPaymentController() *PaymentController {
+func NewPaymentController(db *gorm.DB) *PaymentController {
 	return &PaymentController{
-		PesaPalConfig: pesapal.NewConfig(),
+		DB:      db,
+		PesaPal: pesapal.NewClient(pesapal.NewConfig()),
 	}
 }
 
+// InitiatePayment creates an order for a catalog product and submits it to
+// Pesapal on behalf of the logged-in user. The amount and description come
+// from the product catalog and the billing address from the user's profile,
+// so nothing payment-relevant is taken from the client.
+//
+// Request Body:
+//   - product_code (required): Code of the product in models.Products.
+//     plan_code is accepted as an alias for older clients.
+//
+// Responses:
+//   - 200 OK: The order was submitted; redirect the customer to redirect_url.
+//   - 400 Bad Request: Unknown product or the user has no email or phone number.
+//   - 401 Unauthorized: If the user ID in the token is invalid.
+//   - 500 Internal Server Error: If Pesapal or the database fails.
 func (pc *PaymentController) InitiatePayment(c *gin.Context) {
-	/* Authenticate with PesaPal */
-	authToken, err := pc.PesaPalConfig.Authenticate()
+	userID, err := uuid.Parse(c.GetString("user_id"))
 	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	var input struct {
+		ProductCode string `json:"product_code"`
+		PlanCode    string `json:"plan_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	if input.ProductCode == "" {
+		input.ProductCode = input.PlanCode
+	}
+
+	product, ok := models.Products[input.ProductCode]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown product"})
+		return
+	}
+
+	var user models.User
+	if err := pc.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	/* Pesapal needs at least one way to reach the customer */
+	if user.Email == "" && user.PhoneNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Add an email address or phone number to your profile first"})
+		return
+	}
+
+	/* The billing profile is optional; missing fields are sent empty */
+	var profile models.BillingProfile
+	pc.DB.Where("user_id = ?", userID).First(&profile)
+
+	/* Authenticate with PesaPal (the token is cached by the client) */
+	if _, err := pc.PesaPal.Token(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed", "details": err.Error()})
 		return
 	}
 
-	/* Register IPN URL (only needs to be done once, could be moved to app startup) */
-	ipnResp, err := pc.PesaPalConfig.RegisterIPN(authToken)
-	if err != nil {
+	/* Look up or register the IPN URL (done once per process) */
+	if _, err := pc.PesaPal.IPNID(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "IPN registration failed", "details": err.Error()})
 		return
 	}
 
-	// Set default values for the OrderRequest
 	orderReq := pesapal.OrderRequest{
-		ID:          uuid.NewString(),      // Generate a new UUID for the ID
-		Currency:    "KES",                 // Default currency
-		Description: "Default description", // Default description
-		BillingAddress: pesapal.Address{
-			EmailAddress: "default@example.com", // Default email
-			PhoneNumber:  "+1234567890",         // Default phone number
-			FirstName:    "Default",             // Default first name
-			MiddleName:   "Middle",              // Default middle name
-			LastName:     "User",                // Default last name
-			Line1:        "123 Default Street",  // Default address line 1
-			Line2:        "Suite 456",           // Default address line 2
-			City:         "Default City",        // Default city
-			State:        "Default State",       // Default state
-			PostalCode:   "12345",               // Default postal code
-			ZipCode:      "12345",               // Default zip code
-			CountryCode:  "US",                  // Default country code
-		},
+		ID:             uuid.NewString(), /* The merchant reference is ours, never the client's */
+		Currency:       product.Currency,
+		Amount:         product.Price,
+		Description:    product.Name,
+		BillingAddress: billingAddress(&user, &profile),
 	}
 
-	/* Parse user-provided data from the request body */
-	if err := c.ShouldBindJSON(&orderReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+	/* Recurring plans are renewed by Pesapal against the user's account number */
+	if plan, ok := models.SubscriptionPlans[product.PlanCode]; ok && plan.Recurring {
+		start := time.Now().In(config.EAT)
+		orderReq.AccountNumber = userID.String()
+		orderReq.SubscriptionDetails = &pesapal.SubscriptionDetails{
+			StartDate: start.Format(pesapal.SubscriptionDateLayout),
+			EndDate:   start.AddDate(1, 0, 0).Format(pesapal.SubscriptionDateLayout),
+			Frequency: plan.Frequency,
+		}
+	}
+
+	/* Record the order before submitting it so failed submissions are kept too */
+	order := models.Order{
+		MerchantReference: orderReq.ID,
+		Amount:            orderReq.Amount,
+		Currency:          orderReq.Currency,
+		Description:       orderReq.Description,
+		Email:             orderReq.BillingAddress.EmailAddress,
+		PhoneNumber:       orderReq.BillingAddress.PhoneNumber,
+		ProductCode:       product.Code,
+		PlanCode:          product.PlanCode,
+		AccountNumber:     orderReq.AccountNumber,
+		UserID:            &userID,
+		Status:            models.OrderStatusPending,
+	}
+
+	if err := pc.DB.Create(&order).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record order", "details": err.Error()})
 		return
 	}
 
 	/* Submit order to PesaPal */
-	orderResp, err := pc.PesaPalConfig.SubmitOrder(authToken, ipnResp.ID, orderReq)
+	orderResp, err := pc.PesaPal.SubmitOrder(orderReq)
 	if err != nil {
+		pc.DB.Model(&order).Update("status", models.OrderStatusFailed)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Order submission failed", "details": err.Error()})
 		return
 	}
 
+	/* Keep the tracking ID so IPN callbacks can be matched to the order */
+	if err := pc.DB.Model(&order).Updates(models.Order{
+		TrackingID:  orderResp.OrderTrackingID,
+		RedirectURL: orderResp.RedirectURL,
+	}).Error; err != nil {
+		log.Printf("Failed to save tracking ID for order %s: %v", order.MerchantReference, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Payment initiated successfully",
 		"data": gin.H{
-			"order_tracking_id": orderResp.OrderTrackingID,
-			"redirect_url":      orderResp.RedirectURL,
+			"merchant_reference": order.MerchantReference,
+			"order_tracking_id":  orderResp.OrderTrackingID,
+			"redirect_url":       orderResp.RedirectURL,
 		},
 	})
 }
 
+// billingAddress builds the Pesapal billing address from the user's account
+// and saved billing profile.
+func billingAddress(user *models.User, profile *models.BillingProfile) pesapal.Address {
+	countryCode := profile.CountryCode
+	if countryCode == "" {
+		countryCode = "KE"
+	}
+
+	return pesapal.Address{
+		EmailAddress: user.Email,
+		PhoneNumber:  user.PhoneNumber,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Line1:        profile.Line1,
+		Line2:        profile.Line2,
+		City:         profile.City,
+		State:        profile.State,
+		PostalCode:   profile.PostalCode,
+		CountryCode:  countryCode,
+	}
+}
+
+// GetProducts lists the products that can be paid for.
+//
+// Response:
+//   - HTTP 200 OK: A JSON object with the catalog in the "data" field, cheapest first.
+func (pc *PaymentController) GetProducts(c *gin.Context) {
+	products := make([]models.Product, 0, len(models.Products))
+	for _, product := range models.Products {
+		products = append(products, product)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].Price < products[j].Price })
+
+	c.JSON(http.StatusOK, gin.H{"data": products})
+}
+
+// CheckPaymentStatus fetches the current status of a transaction from Pesapal.
+// The verified status is applied to the stored order as well, so polling and
+// IPN callbacks share one state machine. Only the user who placed the order
+// (or an admin) may check it.
+//
+// Responses:
+//   - 200 OK: The transaction status reported by Pesapal.
+//   - 400 Bad Request: The tracking ID is missing.
+//   - 404 Not Found: No order with this tracking ID belongs to the user.
+//   - 500 Internal Server Error: Pesapal could not be reached.
 func (pc *PaymentController) CheckPaymentStatus(c *gin.Context) {
 	orderTrackingID := c.Param("order_id")
 	if orderTrackingID == "" {
@@ -86,70 +217,260 @@ func (pc *PaymentController) CheckPaymentStatus(c *gin.Context) {
 		return
 	}
 
-	authToken, err := pc.PesaPalConfig.Authenticate()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed", "details": err.Error()})
+	var order models.Order
+	if err := pc.DB.Where("tracking_id = ?", orderTrackingID).First(&order).Error; err != nil || !pc.canViewOrder(c, &order) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
-	statusResp, err := pc.PesaPalConfig.GetTransactionStatus(authToken, orderTrackingID)
+	statusResp, err := pc.PesaPal.GetTransactionStatus(orderTrackingID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transaction status", "details": err.Error()})
 		return
 	}
 
+	/* Keep the stored order in sync with what Pesapal reports */
+	if err := verifyTransactionOwner(&order, orderTrackingID, statusResp); err != nil {
+		log.Printf("Not updating order %s from status check: %v", order.MerchantReference, err)
+	} else if _, err := pc.applyTransactionStatus(&order, orderTrackingID, "STATUSCHECK", statusResp); err != nil {
+		log.Printf("Failed to update order %s from status check: %v", order.MerchantReference, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Payment status retrieved",
 		"data":    statusResp,
 	})
 }
 
+/* canViewOrder reports whether the logged-in user placed the order or is an admin */
+func (pc *PaymentController) canViewOrder(c *gin.Context, order *models.Order) bool {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		return false
+	}
+	return c.GetString("role") == models.RoleAdmin || (order.UserID != nil && *order.UserID == userID)
+}
+
+// HandleIPN receives Instant Payment Notifications sent by Pesapal.
+// Pesapal calls the registered IPN URL with the OrderTrackingId,
+// OrderMerchantReference and OrderNotificationType query parameters.
+// The query string is never trusted on its own: the handler looks up the order
+// by its merchant reference, asks Pesapal for the real transaction status,
+// checks that the status is for that order and moves the order through its
+// state machine. Repeated notifications for a status that was already
+// recorded are acknowledged without being stored again.
+//
+// RECURRING notifications report a renewal charge on a subscription order;
+// they are recorded as renewal orders by applyRecurringPayment.
+//
+// Pesapal expects every notification to be acknowledged with a JSON body
+// echoing the notification and a status of 200 (processed) or 500 (retry later).
+//
+// Responses:
+//   - 200 OK: The notification was processed, ignored as a duplicate, or of an unknown type.
+//   - 400 Bad Request: The tracking ID or merchant reference is missing, or the
+//     transaction does not belong to or pay for the order.
+//   - 404 Not Found: No order matches the merchant reference.
+//   - 500 Internal Server Error: The status could not be verified or stored.
 func (pc *PaymentController) HandleIPN(c *gin.Context) {
-	/* Extract query parameters sent by Pesapal */
-	ipnData := c.Request.URL.Query()
-
-	/* Convert query parameters to a map for easier handling */
-	data := make(map[string]string)
-	for key, values := range ipnData {
-		if len(values) > 0 {
-			data[key] = values[0]
-		}
+	notificationType := c.Query("OrderNotificationType")
+	trackingID := c.Query("OrderTrackingId")
+	merchantReference := c.Query("OrderMerchantReference")
+
+	/* Acknowledgement body expected by Pesapal */
+	ack := func(httpStatus, status int) {
+		c.JSON(httpStatus, gin.H{
+			"orderNotificationType":  notificationType,
+			"orderTrackingId":        trackingID,
+			"orderMerchantReference": merchantReference,
+			"status":                 status,
+		})
 	}
 
-	/* Define the JSON file path */
-	filePath := "/home/bot-on-tapwater/cbcexams-backend/ipn_notifications.json"
+	if trackingID == "" || merchantReference == "" {
+		ack(http.StatusBadRequest, http.StatusInternalServerError)
+		return
+	}
 
-	/* Read existing data from the file (if it exists) */
-	var existingData []map[string]string
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open IPN file", "details": err.Error()})
+	/* Only status changes and renewals carry anything for us to process */
+	if notificationType != "IPNCHANGE" && notificationType != "RECURRING" {
+		log.Printf("Ignoring %q IPN for order %s", notificationType, merchantReference)
+		ack(http.StatusOK, http.StatusOK)
 		return
 	}
-	defer file.Close()
 
-	/* Decode existing JSON data (if any) */
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&existingData); err != nil && err != io.EOF {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to read IPN file", "details": err.Error()})
+	/* Find the order this notification belongs to */
+	var order models.Order
+	if err := pc.DB.Where("merchant_reference = ?", merchantReference).First(&order).Error; err != nil {
+		ack(http.StatusNotFound, http.StatusInternalServerError)
 		return
 	}
 
-	/* Append the new data to the existing data */
-	existingData = append(existingData, data)
+	/* Confirm the real status with Pesapal */
+	statusResp, err := pc.PesaPal.GetTransactionStatus(trackingID)
+	if err != nil {
+		log.Printf("IPN status check failed for order %s: %v", merchantReference, err)
+		ack(http.StatusInternalServerError, http.StatusInternalServerError)
+		return
+	}
 
-	/* Write the updated data back to the file */
-	file.Truncate(0) /* Clear the file */
-	file.Seek(0, 0)  /* Move the pointer to the beginning */
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(existingData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to write IPN file", "details": err.Error(),
-		})
+	if notificationType == "RECURRING" {
+		err = pc.applyRecurringPayment(&order, trackingID, statusResp)
+	} else if err = verifyTransactionOwner(&order, trackingID, statusResp); err == nil {
+		_, err = pc.applyTransactionStatus(&order, trackingID, notificationType, statusResp)
+	}
+	if errors.Is(err, errTransactionMismatch) {
+		log.Printf("Rejected IPN for order %s: %v", merchantReference, err)
+		ack(http.StatusBadRequest, http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to apply IPN for order %s: %v", merchantReference, err)
+		ack(http.StatusInternalServerError, http.StatusInternalServerError)
 		return
 	}
 
 	/* Respond to PesaPal */
-	c.JSON(http.StatusOK, gin.H{"message": "IPN received and stored successfully"})
+	ack(http.StatusOK, http.StatusOK)
+}
+
+/* errTransactionMismatch marks a Pesapal transaction that does not belong to, or does not pay for, an order */
+var errTransactionMismatch = errors.New("transaction does not match the order")
+
+// verifyTransactionOwner checks that a status fetched by tracking ID belongs
+// to the order it is about to be applied to. The order is found from the
+// notification's merchant reference and the status from its tracking ID, so
+// without this check a notification could pair someone else's payment with
+// an unpaid order.
+//
+// Returns:
+//   - error: errTransactionMismatch if Pesapal reports another merchant
+//     reference, or the order is already tied to another tracking ID.
+func verifyTransactionOwner(order *models.Order, trackingID string, statusResp *pesapal.TransactionStatusResponse) error {
+	if statusResp.MerchantReference != order.MerchantReference {
+		return fmt.Errorf("%w: tracking ID %s belongs to merchant reference %q", errTransactionMismatch, trackingID, statusResp.MerchantReference)
+	}
+	if order.TrackingID != "" && trackingID != order.TrackingID {
+		return fmt.Errorf("%w: order is tracked as %s, not %s", errTransactionMismatch, order.TrackingID, trackingID)
+	}
+	return nil
+}
+
+/* paymentMatchesOrder reports whether a transaction paid the order's amount, to the cent, in its currency */
+func paymentMatchesOrder(order *models.Order, statusResp *pesapal.TransactionStatusResponse) bool {
+	return math.Abs(statusResp.Amount-order.Amount) < 0.005 && strings.EqualFold(statusResp.Currency, order.Currency)
+}
+
+// orderStatusFromCode maps a Pesapal status_code to an order status.
+// INVALID (0) means the customer has not paid yet, so the order stays pending.
+func orderStatusFromCode(code int) string {
+	switch code {
+	case pesapal.StatusCodeCompleted:
+		return models.OrderStatusCompleted
+	case pesapal.StatusCodeFailed:
+		return models.OrderStatusFailed
+	case pesapal.StatusCodeReversed:
+		return models.OrderStatusReversed
+	default:
+		return models.OrderStatusPending
+	}
+}
+
+// applyTransactionStatus records a verified Pesapal transaction status against
+// an order and moves the order through its state machine. The order row is
+// locked for the duration of the update so concurrent notifications for the
+// same order are applied one at a time. An order that completes gets a
+// receipt, which is emailed to the customer once the update is committed.
+//
+// Parameters:
+//   - order: The stored order; it is reloaded and updated in place.
+//   - trackingID: The Pesapal order tracking ID the status belongs to.
+//   - notificationType: What triggered the check (e.g. IPNCHANGE).
+//   - statusResp: The status returned by GetTransactionStatus.
+//
+// Returns:
+//   - bool: true if a new history entry was written, false for duplicates and
+//     transitions the state machine does not allow.
+//   - error: errTransactionMismatch if a completed payment is not for the
+//     order's amount and currency, or an error if the database update fails.
+func (pc *PaymentController) applyTransactionStatus(order *models.Order, trackingID, notificationType string, statusResp *pesapal.TransactionStatusResponse) (bool, error) {
+	newStatus := orderStatusFromCode(statusResp.StatusCode)
+	rawPayload, _ := json.Marshal(statusResp)
+	recorded := false
+	var receipt *models.Receipt
+
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, "id = ?", order.ID).Error; err != nil {
+			return err
+		}
+
+		/* Ignore notifications we have already recorded */
+		var duplicates int64
+		if err := tx.Model(&models.PaymentTransaction{}).
+			Where("order_id = ? AND tracking_id = ? AND status = ? AND confirmation_code = ?",
+				order.ID, trackingID, newStatus, statusResp.ConfirmationCode).
+			Count(&duplicates).Error; err != nil {
+			return err
+		}
+		if duplicates > 0 {
+			return nil
+		}
+
+		if newStatus != order.Status && !order.CanTransitionTo(newStatus) {
+			log.Printf("Ignoring transition %s -> %s for order %s", order.Status, newStatus, order.MerchantReference)
+			return nil
+		}
+
+		/* Only a payment of the full amount, in the order's currency, completes it */
+		if newStatus == models.OrderStatusCompleted && !paymentMatchesOrder(order, statusResp) {
+			return fmt.Errorf("%w: paid %.2f %s for an order of %.2f %s",
+				errTransactionMismatch, statusResp.Amount, statusResp.Currency, order.Amount, order.Currency)
+		}
+
+		if err := tx.Create(&models.PaymentTransaction{
+			OrderID:           order.ID,
+			TrackingID:        trackingID,
+			NotificationType:  notificationType,
+			Status:            newStatus,
+			StatusDescription: statusResp.PaymentStatusDescription,
+			PaymentMethod:     statusResp.PaymentMethod,
+			ConfirmationCode:  statusResp.ConfirmationCode,
+			Amount:            statusResp.Amount,
+			RawPayload:        string(rawPayload),
+		}).Error; err != nil {
+			return err
+		}
+		recorded = true
+
+		updates := map[string]interface{}{"status": newStatus}
+		if order.TrackingID == "" {
+			updates["tracking_id"] = trackingID
+		}
+		if err := tx.Model(order).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		previousStatus := order.Status
+		order.Status = newStatus
+
+		/* Issue a receipt and grant or withdraw premium access bought with this order */
+		switch {
+		case previousStatus != newStatus && newStatus == models.OrderStatusCompleted:
+			var err error
+			if receipt, err = issueReceipt(tx, order, statusResp); err != nil {
+				return err
+			}
+			return activateSubscription(tx, order, statusResp)
+		case previousStatus != newStatus && newStatus == models.OrderStatusReversed:
+			return revokeSubscription(tx, order)
+		}
+		return nil
+	})
+
+	/* Only email the receipt once the order is committed as completed */
+	if err == nil && receipt != nil {
+		pc.sendReceipt(receipt, order)
+	}
+
+	return recorded, err
 }
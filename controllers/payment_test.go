@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal/sandbox"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// newSandboxPayments returns a PaymentController whose Pesapal client talks
+// to a sandbox, and a router serving its payment, IPN and cancel handlers.
+// The sandbox's IPNs are delivered to that router. wrap, if set, sees every
+// request to the sandbox first, so a test can act in the middle of a call.
+func newSandboxPayments(t *testing.T, db *gorm.DB, scenario string, wrap func(http.Handler) http.Handler) (*PaymentController, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	pc := &PaymentController{DB: db}
+	router := gin.New()
+	asUser := func(c *gin.Context) { c.Set("user_id", c.GetHeader("X-User-ID")) }
+	router.POST("/payments", asUser, pc.InitiatePayment)
+	router.GET("/payments/ipn", pc.HandleIPN)
+	router.POST("/payments/orders/:id/cancel", pc.CancelOrder)
+
+	appServer := httptest.NewServer(router)
+	t.Cleanup(appServer.Close)
+
+	/* The sandbox needs its own URL for redirect links, so it is created once the server has one */
+	var handler http.Handler
+	sandboxServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler.ServeHTTP(w, r) }))
+	t.Cleanup(sandboxServer.Close)
+	handler = sandbox.NewServer(sandbox.Options{Scenario: scenario, IPNDelay: time.Millisecond, BaseURL: sandboxServer.URL})
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	pc.PesaPal = pesapal.NewClient(&pesapal.Config{
+		ConsumerKey:    "key",
+		ConsumerSecret: "secret",
+		BaseURL:        sandboxServer.URL,
+		CallbackURL:    "https://example.com/payment/callback",
+		IPNURL:         appServer.URL + "/payments/ipn",
+	})
+	return pc, router
+}
+
+/* serve runs a request through the router and decodes the JSON response */
+func serve(t *testing.T, router *gin.Engine, method, path, userID, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", userID)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var decoded map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &decoded)
+	return w.Code, decoded
+}
+
+/* placeOrder buys a product as the user and returns the stored order */
+func placeOrder(t *testing.T, db *gorm.DB, router *gin.Engine, user *models.User, productCode string) models.Order {
+	t.Helper()
+	code, body := serve(t, router, http.MethodPost, "/payments", user.ID.String(), `{"product_code":"`+productCode+`"}`)
+	if code != http.StatusOK {
+		t.Fatalf("InitiatePayment answered %d: %v", code, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+
+	var order models.Order
+	if err := db.First(&order, "merchant_reference = ?", data["merchant_reference"]).Error; err != nil {
+		t.Fatalf("loading the order: %v", err)
+	}
+	if order.TrackingID == "" {
+		t.Fatal("the order has no tracking ID")
+	}
+	return order
+}
+
+/* activeSubscriptions counts the active subscriptions an order granted */
+func activeSubscriptions(t *testing.T, db *gorm.DB, order *models.Order) int64 {
+	t.Helper()
+	var count int64
+	if err := db.Model(&models.Subscription{}).
+		Where("order_id = ? AND status = ?", order.ID, models.SubscriptionStatusActive).
+		Count(&count).Error; err != nil {
+		t.Fatalf("counting subscriptions: %v", err)
+	}
+	return count
+}
+
+func TestCancelOrder(t *testing.T) {
+	db := testDB(t)
+	_, router := newSandboxPayments(t, db, sandbox.ScenarioManual, nil)
+	user := createTestUser(t, db)
+	order := placeOrder(t, db, router, user, "weekly")
+
+	code, body := serve(t, router, http.MethodPost, "/payments/orders/"+order.ID.String()+"/cancel", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("CancelOrder answered %d: %v", code, body)
+	}
+
+	db.First(&order, "id = ?", order.ID)
+	if order.Status != models.OrderStatusCancelled {
+		t.Errorf("status = %s, want %s", order.Status, models.OrderStatusCancelled)
+	}
+}
+
+func TestCancelOrderCompletedDuringCancellation(t *testing.T) {
+	db := testDB(t)
+	var pc *PaymentController
+	var order models.Order
+
+	/* The payment completes while Pesapal is being asked to cancel the order */
+	pc, router := newSandboxPayments(t, db, sandbox.ScenarioManual, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/Transactions/CancelOrder" {
+				if _, err := pc.applyTransactionStatus(&order, order.TrackingID, "IPNCHANGE", &pesapal.TransactionStatusResponse{
+					StatusCode:        pesapal.StatusCodeCompleted,
+					MerchantReference: order.MerchantReference,
+					Amount:            order.Amount,
+					Currency:          order.Currency,
+					ConfirmationCode:  "TEST" + order.TrackingID,
+				}); err != nil {
+					t.Errorf("completing the order: %v", err)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	user := createTestUser(t, db)
+	order = placeOrder(t, db, router, user, "weekly")
+
+	code, body := serve(t, router, http.MethodPost, "/payments/orders/"+order.ID.String()+"/cancel", "", "")
+	if code != http.StatusConflict {
+		t.Fatalf("CancelOrder answered %d, want %d: %v", code, http.StatusConflict, body)
+	}
+
+	var stored models.Order
+	db.First(&stored, "id = ?", order.ID)
+	if stored.Status != models.OrderStatusCompleted {
+		t.Errorf("status = %s, want %s", stored.Status, models.OrderStatusCompleted)
+	}
+	if activeSubscriptions(t, db, &stored) != 1 {
+		t.Error("the completed order's subscription is not active")
+	}
+}
+
+/* waitForOrderStatus polls until the sandbox's IPN has moved the order to status */
+func waitForOrderStatus(t *testing.T, db *gorm.DB, order *models.Order, status string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := db.First(order, "id = ?", order.ID).Error; err != nil {
+			t.Fatalf("loading the order: %v", err)
+		}
+		if order.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("order is %s, want %s", order.Status, status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestPaymentEndToEnd(t *testing.T) {
+	db := testDB(t)
+	_, router := newSandboxPayments(t, db, sandbox.ScenarioSuccess, nil)
+	user := createTestUser(t, db)
+
+	order := placeOrder(t, db, router, user, "weekly")
+	if order.Amount != models.SubscriptionPlans["weekly"].Price || order.UserID == nil || *order.UserID != user.ID {
+		t.Fatalf("new order = %+v", order)
+	}
+
+	waitForOrderStatus(t, db, &order, models.OrderStatusCompleted)
+
+	var transactions []models.PaymentTransaction
+	db.Where("order_id = ?", order.ID).Find(&transactions)
+	if len(transactions) != 1 || transactions[0].TrackingID != order.TrackingID || transactions[0].ConfirmationCode == "" {
+		t.Errorf("transactions = %+v, want one completed transaction with a confirmation code", transactions)
+	}
+
+	var subscription models.Subscription
+	if err := db.First(&subscription, "order_id = ?", order.ID).Error; err != nil {
+		t.Fatalf("no subscription for the paid order: %v", err)
+	}
+	if subscription.UserID != user.ID || subscription.PlanCode != "weekly" || !subscription.IsActive(time.Now()) {
+		t.Errorf("subscription = %+v, want an active weekly subscription for the buyer", subscription)
+	}
+
+	var receipts int64
+	db.Model(&models.Receipt{}).Where("order_id = ?", order.ID).Count(&receipts)
+	if receipts != 1 {
+		t.Errorf("%d receipts, want 1", receipts)
+	}
+
+	/* Pesapal retries IPNs; a repeat changes nothing */
+	code, _ := serve(t, router, http.MethodGet, "/payments/ipn?OrderNotificationType=IPNCHANGE&OrderTrackingId="+order.TrackingID+
+		"&OrderMerchantReference="+order.MerchantReference, "", "")
+	if code != http.StatusOK {
+		t.Errorf("repeated IPN answered %d", code)
+	}
+	var count int64
+	db.Model(&models.Subscription{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("%d subscriptions after a repeated IPN, want 1", count)
+	}
+}
+
+func TestFailedPaymentEndToEnd(t *testing.T) {
+	db := testDB(t)
+	_, router := newSandboxPayments(t, db, sandbox.ScenarioFailure, nil)
+	user := createTestUser(t, db)
+
+	order := placeOrder(t, db, router, user, "weekly")
+	waitForOrderStatus(t, db, &order, models.OrderStatusFailed)
+
+	var count int64
+	db.Model(&models.Subscription{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d subscriptions for a failed payment, want 0", count)
+	}
+}
+
+func TestIPNForAnotherOrderIsRejected(t *testing.T) {
+	db := testDB(t)
+	_, router := newSandboxPayments(t, db, sandbox.ScenarioManual, nil)
+
+	other := placeOrder(t, db, router, createTestUser(t, db), "weekly")
+	unpaid := placeOrder(t, db, router, createTestUser(t, db), "weekly")
+
+	/* Pair the unpaid order's reference with the other order's tracking ID */
+	code, _ := serve(t, router, http.MethodGet, "/payments/ipn?OrderNotificationType=IPNCHANGE&OrderTrackingId="+other.TrackingID+
+		"&OrderMerchantReference="+unpaid.MerchantReference, "", "")
+	if code != http.StatusBadRequest {
+		t.Errorf("mismatched IPN answered %d, want %d", code, http.StatusBadRequest)
+	}
+
+	db.First(&unpaid, "id = ?", unpaid.ID)
+	if unpaid.Status != models.OrderStatusPending || activeSubscriptions(t, db, &unpaid) != 0 {
+		t.Errorf("a mismatched IPN changed the order: %+v", unpaid)
+	}
+}
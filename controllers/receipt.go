@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/* Layout used for dates printed on receipts, always in EAT */
+const receiptTimeLayout = "02 Jan 2006, 15:04 EAT"
+
+var receiptTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Helvetica, Arial, sans-serif; color: #222;">
+  <h2>CBC Exams &mdash; Payment Receipt</h2>
+  <p>Receipt number: <b>{{.Number}}</b></p>
+  <table cellpadding="6" style="border-collapse: collapse;">
+    <tr><td>Order</td><td>{{.MerchantReference}}</td></tr>
+    <tr><td>Description</td><td>{{.Description}}</td></tr>
+    <tr><td>Amount</td><td><b>{{.Currency}} {{printf "%.2f" .Amount}}</b></td></tr>
+    <tr><td>Payment method</td><td>{{.PaymentMethod}}</td></tr>
+    <tr><td>Confirmation code</td><td>{{.ConfirmationCode}}</td></tr>
+    <tr><td>Paid on</td><td>{{.PaidAt}}</td></tr>
+  </table>
+  <p>Thank you for your payment.</p>
+</body>
+</html>`))
+
+/* receiptView is the data printed on a receipt, in both HTML and PDF form */
+type receiptView struct {
+	Number            string
+	MerchantReference string
+	Description       string
+	Amount            float64
+	Currency          string
+	PaymentMethod     string
+	ConfirmationCode  string
+	PaidAt            string
+}
+
+func newReceiptView(receipt *models.Receipt, order *models.Order) receiptView {
+	return receiptView{
+		Number:            receipt.Number,
+		MerchantReference: order.MerchantReference,
+		Description:       receipt.Description,
+		Amount:            receipt.Amount,
+		Currency:          receipt.Currency,
+		PaymentMethod:     receipt.PaymentMethod,
+		ConfirmationCode:  receipt.ConfirmationCode,
+		PaidAt:            receipt.PaidAt.In(config.EAT).Format(receiptTimeLayout),
+	}
+}
+
+/* renderReceiptHTML renders a receipt as an HTML document */
+func renderReceiptHTML(view receiptView) (string, error) {
+	var buf bytes.Buffer
+	if err := receiptTemplate.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+/* renderReceiptPDF renders a receipt as a one-page PDF */
+func renderReceiptPDF(view receiptView) []byte {
+	page := utils.NewPDFPage()
+	page.Text(60, 770, 18, true, "CBC Exams - Payment Receipt")
+	page.Text(60, 745, 11, false, "Receipt number: "+view.Number)
+	page.Line(60, 730, 535, 730)
+
+	rows := [][2]string{
+		{"Order", view.MerchantReference},
+		{"Description", view.Description},
+		{"Amount", fmt.Sprintf("%s %.2f", view.Currency, view.Amount)},
+		{"Payment method", view.PaymentMethod},
+		{"Confirmation code", view.ConfirmationCode},
+		{"Paid on", view.PaidAt},
+	}
+	y := 705.0
+	for _, row := range rows {
+		page.Text(60, y, 11, true, row[0])
+		page.Text(200, y, 11, false, row[1])
+		y -= 22
+	}
+
+	page.Line(60, y, 535, y)
+	page.Text(60, y-25, 10, false, "Thank you for your payment.")
+	return page.Bytes()
+}
+
+// issueReceipt records the receipt for an order that has just completed.
+// It runs inside the transaction that completes the order and is a no-op if
+// the order already has a receipt.
+//
+// Parameters:
+//   - tx: The database transaction the order update runs in.
+//   - order: The order that has just completed.
+//   - statusResp: The verified transaction status reported by Pesapal.
+//
+// Returns:
+//   - *models.Receipt: The new receipt, or nil if one already existed.
+//   - error: An error if the receipt cannot be stored.
+func issueReceipt(tx *gorm.DB, order *models.Order, statusResp *pesapal.TransactionStatusResponse) (*models.Receipt, error) {
+	var existing int64
+	if err := tx.Model(&models.Receipt{}).Where("order_id = ?", order.ID).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, nil
+	}
+
+	amount := statusResp.Amount
+	if amount == 0 {
+		amount = order.Amount
+	}
+
+	receipt := models.Receipt{
+		OrderID:          order.ID,
+		UserID:           order.UserID,
+		Amount:           amount,
+		Currency:         order.Currency,
+		Description:      order.Description,
+		ConfirmationCode: statusResp.ConfirmationCode,
+		PaymentMethod:    statusResp.PaymentMethod,
+		PaidAt:           time.Now().In(config.EAT),
+	}
+	if !statusResp.CreatedDate.IsZero() {
+		receipt.PaidAt = statusResp.CreatedDate.In(config.EAT)
+	}
+
+	if err := tx.Create(&receipt).Error; err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+/* sendReceipt emails a newly issued receipt to the customer who placed the order */
+func (pc *PaymentController) sendReceipt(receipt *models.Receipt, order *models.Order) {
+	email := order.Email
+	if order.UserID != nil {
+		var user models.User
+		if err := pc.DB.Select("id", "email").First(&user, "id = ?", *order.UserID).Error; err == nil && user.Email != "" {
+			email = user.Email
+		}
+	}
+	if email == "" {
+		log.Printf("No email address to send receipt %s to", receipt.Number)
+		return
+	}
+
+	view := newReceiptView(receipt, order)
+	html, err := renderReceiptHTML(view)
+	if err != nil {
+		log.Printf("Failed to render receipt %s: %v", receipt.Number, err)
+		return
+	}
+
+	if err := utils.SendReceiptEmail(email, receipt.Number, html, renderReceiptPDF(view)); err != nil {
+		log.Printf("Failed to send receipt %s: %v", receipt.Number, err)
+	}
+}
+
+// GetReceipts lists the receipts issued to the logged-in user, newest first.
+//
+// Responses:
+//   - 200 OK: {"data": [...]}.
+//   - 401 Unauthorized: If the user ID in the token is invalid.
+//   - 500 Internal Server Error: If the receipts cannot be fetched.
+func (pc *PaymentController) GetReceipts(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	var receipts []models.Receipt
+	if err := pc.DB.Where("user_id = ?", userID).Order("sequence DESC").Find(&receipts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch receipts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": receipts})
+}
+
+// GetReceipt downloads one of the logged-in user's receipts. Admins may
+// download any receipt.
+//
+// Query Parameters:
+//   - format (optional): "pdf" (default), "html" or "json".
+//
+// Responses:
+//   - 200 OK: The receipt in the requested format.
+//   - 400 Bad Request: Invalid receipt ID or format.
+//   - 404 Not Found: No receipt with this ID belongs to the user.
+//   - 500 Internal Server Error: If the receipt cannot be rendered.
+func (pc *PaymentController) GetReceipt(c *gin.Context) {
+	receiptID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receipt ID"})
+		return
+	}
+
+	var receipt models.Receipt
+	if err := pc.DB.Preload("Order").First(&receipt, "id = ?", receiptID).Error; err != nil || !pc.canViewOrder(c, &receipt.Order) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+
+	view := newReceiptView(&receipt, &receipt.Order)
+
+	switch c.DefaultQuery("format", "pdf") {
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", receipt.Number+".pdf"))
+		c.Data(http.StatusOK, "application/pdf", renderReceiptPDF(view))
+	case "html":
+		html, err := renderReceiptHTML(view)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render receipt", "details": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+	case "json":
+		c.JSON(http.StatusOK, gin.H{"data": receipt})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format; use pdf, html or json"})
+	}
+}
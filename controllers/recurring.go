@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// applyRecurringPayment records a renewal charge reported by a RECURRING IPN.
+//
+// Each renewal is stored as its own order, linked to the original subscription
+// order through ParentOrderID and identified by the renewal's tracking ID, so
+// finance sees every charge and the usual state machine applies to it. When
+// the renewal completes, activateSubscription extends the user's entitlement
+// by one plan period; when it fails, the user is emailed so they can update
+// their payment details before access runs out.
+//
+// A charge is only accepted for a recurring plan's order, under that order's
+// merchant reference and account number, with a tracking ID of its own and,
+// once it completes, for the order's amount and currency.
+//
+// Parameters:
+//   - parent: The original subscription order named by OrderMerchantReference.
+//   - trackingID: The tracking ID of the renewal charge.
+//   - statusResp: The verified status of the renewal charge.
+//
+// Returns:
+//   - error: errTransactionMismatch if the charge is not a renewal of parent,
+//     or an error if the renewal cannot be stored.
+func (pc *PaymentController) applyRecurringPayment(parent *models.Order, trackingID string, statusResp *pesapal.TransactionStatusResponse) error {
+	/* Nothing to record until the charge has either gone through or failed */
+	if orderStatusFromCode(statusResp.StatusCode) == models.OrderStatusPending {
+		return nil
+	}
+
+	if err := verifyRenewal(parent, trackingID, statusResp); err != nil {
+		return err
+	}
+
+	var renewal models.Order
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		/* Serialise renewals of the same subscription */
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Order{}, "id = ?", parent.ID).Error; err != nil {
+			return err
+		}
+
+		err := tx.Where("parent_order_id = ? AND tracking_id = ?", parent.ID, trackingID).First(&renewal).Error
+		if err == nil {
+			return nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		renewal = models.Order{
+			MerchantReference: uuid.NewString(),
+			TrackingID:        trackingID,
+			UserID:            parent.UserID,
+			ParentOrderID:     &parent.ID,
+			Amount:            parent.Amount,
+			Currency:          parent.Currency,
+			Description:       parent.Description + " (renewal)",
+			Email:             parent.Email,
+			PhoneNumber:       parent.PhoneNumber,
+			PlanCode:          parent.PlanCode,
+			AccountNumber:     parent.AccountNumber,
+			Status:            models.OrderStatusPending,
+		}
+		return tx.Create(&renewal).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	recorded, err := pc.applyTransactionStatus(&renewal, trackingID, "RECURRING", statusResp)
+	if err != nil {
+		return err
+	}
+
+	if recorded && renewal.Status == models.OrderStatusFailed {
+		pc.notifyRenewalFailed(&renewal)
+	}
+	return nil
+}
+
+/* verifyRenewal checks that a RECURRING charge renews the subscription order it was reported against */
+func verifyRenewal(parent *models.Order, trackingID string, statusResp *pesapal.TransactionStatusResponse) error {
+	plan, ok := models.SubscriptionPlans[parent.PlanCode]
+	switch {
+	case !ok || !plan.Recurring || parent.AccountNumber == "":
+		return fmt.Errorf("%w: order %s is not a recurring subscription", errTransactionMismatch, parent.MerchantReference)
+	case trackingID == parent.TrackingID:
+		return fmt.Errorf("%w: tracking ID %s is the original charge, not a renewal", errTransactionMismatch, trackingID)
+	case statusResp.MerchantReference != parent.MerchantReference:
+		return fmt.Errorf("%w: tracking ID %s belongs to merchant reference %q", errTransactionMismatch, trackingID, statusResp.MerchantReference)
+	case statusResp.SubscriptionTransactionInfo == nil || statusResp.SubscriptionTransactionInfo.AccountReference != parent.AccountNumber:
+		return fmt.Errorf("%w: tracking ID %s is not charged to account %s", errTransactionMismatch, trackingID, parent.AccountNumber)
+	case orderStatusFromCode(statusResp.StatusCode) == models.OrderStatusCompleted && !paymentMatchesOrder(parent, statusResp):
+		return fmt.Errorf("%w: renewal paid %.2f %s for a plan of %.2f %s",
+			errTransactionMismatch, statusResp.Amount, statusResp.Currency, parent.Amount, parent.Currency)
+	}
+	return nil
+}
+
+/* notifyRenewalFailed emails the owner of a renewal order whose charge failed */
+func (pc *PaymentController) notifyRenewalFailed(renewal *models.Order) {
+	email := renewal.Email
+	if renewal.UserID != nil {
+		var user models.User
+		if err := pc.DB.Select("id", "email").First(&user, "id = ?", *renewal.UserID).Error; err == nil {
+			email = user.Email
+		}
+	}
+	if email == "" {
+		log.Printf("No email address to notify about failed renewal %s", renewal.MerchantReference)
+		return
+	}
+
+	planName := renewal.Description
+	if plan, ok := models.SubscriptionPlans[renewal.PlanCode]; ok {
+		planName = plan.Name
+	}
+
+	if err := utils.SendRenewalFailedEmail(email, planName); err != nil {
+		log.Printf("Failed to send renewal failure email for %s: %v", renewal.MerchantReference, err)
+	}
+}
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/* errRefundRefused aborts a refund transaction whose response has already been chosen */
+var errRefundRefused = errors.New("refund refused")
+
+/* errOrderMovedOn aborts a cancellation when the order's status changed while Pesapal was being asked */
+var errOrderMovedOn = errors.New("order status changed")
+
+// RefundOrder asks Pesapal to refund a completed order, in full or in part,
+// and records the outcome against the order. Admin only.
+//
+// Request Body:
+//   - amount (optional): Amount to refund. Defaults to whatever has not been refunded yet.
+//   - remarks (required): Reason for the refund, forwarded to Pesapal.
+//   - confirmation_code (optional): Pesapal confirmation code of the payment.
+//     Defaults to the code recorded when the order completed.
+//
+// Responses:
+//   - 201 Created: Pesapal accepted the refund request.
+//   - 400 Bad Request: Invalid input, the order is not completed or the amount is too large.
+//   - 401 Unauthorized: The admin's account cannot be loaded.
+//   - 404 Not Found: No order with the given ID.
+//   - 500 Internal Server Error: The refund could not be recorded.
+//   - 502 Bad Gateway: Pesapal could not be reached or rejected the request.
+func (pc *PaymentController) RefundOrder(c *gin.Context) {
+	adminID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	var input struct {
+		Amount           float64 `json:"amount" binding:"gte=0"`
+		Remarks          string  `json:"remarks" binding:"required"`
+		ConfirmationCode string  `json:"confirmation_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, ok := pc.findOrder(c)
+	if !ok {
+		return
+	}
+
+	var admin models.User
+	if err := pc.DB.Select("id", "email").First(&admin, "id = ?", adminID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	/* Set when the request is refused inside the transaction */
+	var failStatus int
+	var failBody gin.H
+
+	var refund models.Refund
+	var refundResp *pesapal.ActionResponse
+
+	/*
+		The order row stays locked from summing earlier refunds until this one is
+		recorded, so concurrent requests cannot both refund the same balance.
+	*/
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", order.ID).Error; err != nil {
+			return err
+		}
+
+		if order.Status != models.OrderStatusCompleted {
+			failStatus, failBody = http.StatusBadRequest, gin.H{"error": "Only completed orders can be refunded"}
+			return errRefundRefused
+		}
+
+		/* Work out how much is still refundable */
+		var refunded float64
+		if err := tx.Model(&models.Refund{}).
+			Where("order_id = ? AND status = ?", order.ID, models.RefundStatusRequested).
+			Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error; err != nil {
+			return err
+		}
+
+		refundable := order.Amount - refunded
+		amount := input.Amount
+		if amount == 0 {
+			amount = refundable
+		}
+		if amount <= 0 || amount > refundable {
+			failStatus, failBody = http.StatusBadRequest, gin.H{"error": "Refund amount exceeds the refundable balance", "refundable": refundable}
+			return errRefundRefused
+		}
+
+		/* Default to the confirmation code recorded when the payment completed */
+		confirmationCode := input.ConfirmationCode
+		if confirmationCode == "" {
+			var completed models.PaymentTransaction
+			if err := tx.Where("order_id = ? AND status = ? AND confirmation_code <> ''", order.ID, models.OrderStatusCompleted).
+				Order("created_at DESC").First(&completed).Error; err != nil {
+				failStatus, failBody = http.StatusBadRequest, gin.H{"error": "No confirmation code recorded for this order"}
+				return errRefundRefused
+			}
+			confirmationCode = completed.ConfirmationCode
+		}
+
+		var err error
+		refundResp, err = pc.PesaPal.RequestRefund(pesapal.RefundRequest{
+			ConfirmationCode: confirmationCode,
+			Amount:           amount,
+			Username:         admin.Email,
+			Remarks:          input.Remarks,
+		})
+		if err != nil {
+			failStatus, failBody = http.StatusBadGateway, gin.H{"error": "Refund request failed", "details": err.Error()}
+			return errRefundRefused
+		}
+
+		refund = models.Refund{
+			OrderID:          order.ID,
+			Amount:           amount,
+			ConfirmationCode: confirmationCode,
+			Remarks:          input.Remarks,
+			RequestedBy:      adminID,
+			Status:           models.RefundStatusRequested,
+			Message:          refundResp.Message,
+		}
+		if !refundResp.Succeeded() {
+			refund.Status = models.RefundStatusRejected
+		}
+		return tx.Create(&refund).Error
+	})
+	if errors.Is(err, errRefundRefused) {
+		c.JSON(failStatus, failBody)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record refund", "details": err.Error()})
+		return
+	}
+
+	if !refundResp.Succeeded() {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Pesapal rejected the refund", "data": refund})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Refund requested", "data": refund})
+}
+
+// CancelOrder cancels an order that has not been paid yet, both with Pesapal
+// and in the database. Admin only.
+//
+// Responses:
+//   - 200 OK: The order was cancelled.
+//   - 400 Bad Request: The order has been paid or was never submitted to Pesapal.
+//   - 404 Not Found: No order with the given ID.
+//   - 409 Conflict: The order was paid or changed while it was being cancelled.
+//   - 500 Internal Server Error: The cancellation could not be recorded.
+//   - 502 Bad Gateway: Pesapal could not be reached or refused to cancel the order.
+func (pc *PaymentController) CancelOrder(c *gin.Context) {
+	order, ok := pc.findOrder(c)
+	if !ok {
+		return
+	}
+
+	if !order.CanTransitionTo(models.OrderStatusCancelled) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only unpaid orders can be cancelled"})
+		return
+	}
+	if order.TrackingID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order was never submitted to Pesapal"})
+		return
+	}
+
+	cancelResp, err := pc.PesaPal.CancelOrder(order.TrackingID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Cancellation request failed", "details": err.Error()})
+		return
+	}
+	if !cancelResp.Succeeded() {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Pesapal refused to cancel the order", "details": cancelResp.Message})
+		return
+	}
+
+	/*
+		An IPN may have completed the order while Pesapal was being asked, so the
+		order is locked and checked again before it is marked cancelled.
+	*/
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", order.ID).Error; err != nil {
+			return err
+		}
+		if !order.CanTransitionTo(models.OrderStatusCancelled) {
+			return errOrderMovedOn
+		}
+
+		if err := tx.Create(&models.PaymentTransaction{
+			OrderID:           order.ID,
+			TrackingID:        order.TrackingID,
+			NotificationType:  "CANCEL",
+			Status:            models.OrderStatusCancelled,
+			StatusDescription: cancelResp.Message,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&order).Update("status", models.OrderStatusCancelled).Error
+	})
+	if errors.Is(err, errOrderMovedOn) {
+		log.Printf("Pesapal cancelled order %s but it is now %s", order.MerchantReference, order.Status)
+		c.JSON(http.StatusConflict, gin.H{"error": "The order changed while it was being cancelled", "data": order})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record cancellation", "details": err.Error()})
+		return
+	}
+	order.Status = models.OrderStatusCancelled
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled", "data": order})
+}
+
+/* findOrder loads the order named by the :id route parameter, responding on failure */
+func (pc *PaymentController) findOrder(c *gin.Context) (models.Order, bool) {
+	var order models.Order
+
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return order, false
+	}
+
+	if err := pc.DB.First(&order, "id = ?", orderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return order, false
+	}
+
+	return order, true
+}
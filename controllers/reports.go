@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+/* PaymentHistoryItem is one order in a user's payment history */
+type PaymentHistoryItem struct {
+	models.Order
+	ReceiptID *uuid.UUID `json:"receipt_id"`
+}
+
+// GetMyPayments lists the logged-in user's orders, newest first, with their
+// current status and the receipt issued for completed ones.
+//
+// Query Parameters:
+//   - status (optional): Only return orders in this status (e.g. COMPLETED).
+//   - page, limit (optional): Pagination, defaults 1 and 20.
+//
+// Responses:
+//   - 200 OK: {"data": [...], "pagination": {...}}.
+//   - 401 Unauthorized: If the user ID in the token is invalid.
+//   - 500 Internal Server Error: If the orders cannot be fetched.
+func (pc *PaymentController) GetMyPayments(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	page := c.DefaultQuery("page", "1")
+	limit := c.DefaultQuery("limit", "20")
+	pageInt, _ := strconv.Atoi(page)
+	limitInt, _ := strconv.Atoi(limit)
+	if pageInt < 1 {
+		pageInt = 1
+	}
+	if limitInt < 1 {
+		limitInt = 20
+	}
+
+	query := pc.DB.Model(&models.Order{}).Where("user_id = ?", userID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var totalRecords int64
+	if err := query.Count(&totalRecords).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	var orders []models.Order
+	if err := query.Order("created_at DESC").Scopes(Paginate(page, limit)).Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+
+	/* Attach the receipt of each completed order */
+	orderIDs := make([]uuid.UUID, len(orders))
+	for i, order := range orders {
+		orderIDs[i] = order.ID
+	}
+	var receipts []models.Receipt
+	if len(orderIDs) > 0 {
+		pc.DB.Select("id", "order_id").Where("order_id IN ?", orderIDs).Find(&receipts)
+	}
+	receiptByOrder := make(map[uuid.UUID]uuid.UUID, len(receipts))
+	for _, receipt := range receipts {
+		receiptByOrder[receipt.OrderID] = receipt.ID
+	}
+
+	items := make([]PaymentHistoryItem, len(orders))
+	for i, order := range orders {
+		items[i] = PaymentHistoryItem{Order: order}
+		if receiptID, ok := receiptByOrder[order.ID]; ok {
+			items[i].ReceiptID = &receiptID
+		}
+	}
+
+	totalPages := int((totalRecords + int64(limitInt) - 1) / int64(limitInt))
+	nextPage := pageInt + 1
+	if nextPage > totalPages {
+		nextPage = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": items,
+		"pagination": gin.H{
+			"total_records": totalRecords,
+			"total_pages":   totalPages,
+			"current_page":  pageInt,
+			"next_page":     nextPage,
+			"limit":         limitInt,
+		},
+	})
+}
+
+/* RevenueRow is one line of a revenue report */
+type RevenueRow struct {
+	Key            string  `json:"key"`
+	Currency       string  `json:"currency"`
+	Orders         int64   `json:"orders"`
+	Revenue        float64 `json:"revenue"`
+	Reversed       float64 `json:"reversed"`        /* Paid orders Pesapal has since reversed */
+	RefundsPending float64 `json:"refunds_pending"` /* Refunds requested on orders Pesapal has not reversed yet */
+	Net            float64 `json:"net"`             /* Revenue less reversals */
+}
+
+/*
+revenueGroupings maps each report dimension to the SQL expression it groups by.
+paid_at is the time of the transaction that completed the order, in EAT.
+*/
+var revenueGroupings = map[string]string{
+	"day":            "to_char(date_trunc('day', t.paid_at AT TIME ZONE 'Africa/Nairobi'), 'YYYY-MM-DD')",
+	"week":           "to_char(date_trunc('week', t.paid_at AT TIME ZONE 'Africa/Nairobi'), 'YYYY-MM-DD')",
+	"month":          "to_char(date_trunc('month', t.paid_at AT TIME ZONE 'Africa/Nairobi'), 'YYYY-MM')",
+	"product":        "COALESCE(NULLIF(o.product_code, ''), NULLIF(o.plan_code, ''), 'other')",
+	"payment_method": "COALESCE(NULLIF(t.payment_method, ''), 'unknown')",
+}
+
+// GetRevenueByPeriod reports revenue per day, week or month. Admin only.
+//
+// Query Parameters:
+//   - period (optional): "day" (default), "week" or "month".
+//   - from, to, format: See revenueReport.
+func (pc *PaymentController) GetRevenueByPeriod(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	if period != "day" && period != "week" && period != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day, week or month"})
+		return
+	}
+	pc.revenueReport(c, period)
+}
+
+// GetRevenueByProduct reports revenue per product. Admin only.
+func (pc *PaymentController) GetRevenueByProduct(c *gin.Context) {
+	pc.revenueReport(c, "product")
+}
+
+// GetRevenueByPaymentMethod reports revenue per Pesapal payment method
+// (e.g. M-Pesa, Visa). Admin only.
+func (pc *PaymentController) GetRevenueByPaymentMethod(c *gin.Context) {
+	pc.revenueReport(c, "payment_method")
+}
+
+// revenueReport computes revenue from the stored orders and their payment
+// history, grouped by the given dimension. Every order that was paid counts as
+// revenue. Pesapal confirms a refund by reversing the order, so only reversed
+// orders are subtracted from the net amount; refunds requested on orders that
+// are still completed are reported as pending and not subtracted.
+//
+// Query Parameters:
+//   - from (optional): First day to include, YYYY-MM-DD in EAT. Defaults to 30 days ago.
+//   - to (optional): Last day to include, YYYY-MM-DD in EAT. Defaults to today.
+//   - format (optional): "json" (default) or "csv".
+//
+// Responses:
+//   - 200 OK: The report as JSON or as a CSV download.
+//   - 400 Bad Request: Invalid dates or format.
+//   - 500 Internal Server Error: If the report cannot be computed.
+func (pc *PaymentController) revenueReport(c *gin.Context, groupBy string) {
+	today := time.Now().In(config.EAT)
+	from, err := parseReportDate(c.Query("from"), today.AddDate(0, 0, -30))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, use YYYY-MM-DD"})
+		return
+	}
+	to, err := parseReportDate(c.Query("to"), today)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, use YYYY-MM-DD"})
+		return
+	}
+	to = to.AddDate(0, 0, 1) /* Include the whole of the last day */
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	groupExpr := revenueGroupings[groupBy]
+	query := fmt.Sprintf(`
+		SELECT %s AS key, o.currency AS currency, COUNT(*) AS orders,
+			SUM(o.amount) AS revenue,
+			COALESCE(SUM(o.amount) FILTER (WHERE o.status = ?), 0) AS reversed,
+			COALESCE(SUM(r.amount) FILTER (WHERE o.status = ?), 0) AS refunds_pending
+		FROM orders o
+		JOIN (
+			SELECT DISTINCT ON (order_id) order_id, created_at AS paid_at, payment_method
+			FROM payment_transactions
+			WHERE status = ?
+			ORDER BY order_id, created_at
+		) t ON t.order_id = o.id
+		LEFT JOIN (
+			SELECT order_id, SUM(amount) AS amount
+			FROM refunds
+			WHERE status = ?
+			GROUP BY order_id
+		) r ON r.order_id = o.id
+		WHERE o.status IN ? AND t.paid_at >= ? AND t.paid_at < ?
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, groupExpr)
+
+	var rows []RevenueRow
+	if err := pc.DB.Raw(query,
+		models.OrderStatusReversed, models.OrderStatusCompleted,
+		models.OrderStatusCompleted, models.RefundStatusRequested,
+		[]string{models.OrderStatusCompleted, models.OrderStatusReversed}, from, to,
+	).Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute revenue report", "details": err.Error()})
+		return
+	}
+	for i := range rows {
+		rows[i].Net = rows[i].Revenue - rows[i].Reversed
+	}
+
+	if format == "csv" {
+		filename := fmt.Sprintf("revenue-by-%s-%s-%s.csv", groupBy, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Header("Content-Type", "text/csv")
+
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{groupBy, "currency", "orders", "revenue", "reversed", "refunds_pending", "net"})
+		for _, row := range rows {
+			w.Write([]string{
+				row.Key,
+				row.Currency,
+				strconv.FormatInt(row.Orders, 10),
+				strconv.FormatFloat(row.Revenue, 'f', 2, 64),
+				strconv.FormatFloat(row.Reversed, 'f', 2, 64),
+				strconv.FormatFloat(row.RefundsPending, 'f', 2, 64),
+				strconv.FormatFloat(row.Net, 'f', 2, 64),
+			})
+		}
+		w.Flush()
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by": groupBy,
+		"from":     from.Format("2006-01-02"),
+		"to":       to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"data":     rows,
+	})
+}
+
+/* parseReportDate parses a YYYY-MM-DD date as midnight EAT, or returns fallback's day when empty */
+func parseReportDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Date(fallback.Year(), fallback.Month(), fallback.Day(), 0, 0, 0, 0, config.EAT), nil
+	}
+	return time.ParseInLocation("2006-01-02", value, config.EAT)
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/database"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/* Largest bulk import accepted over HTTP; bigger files can be imported with cmd/import-resources */
+const resourceImportMaxBytes = 200 << 20
+
+// resourceInUse reports whether another resource, deleted ones included,
+// already has the input's django_relative_path or Drive link. Both are
+// unique in the table.
+func (rc *ResourceController) resourceInUse(input *models.ResourceInput, except uuid.UUID) (bool, error) {
+	var count int64
+	err := rc.DB.Unscoped().Model(&models.WebCrawlerResource{}).
+		Where("id <> ?", except).
+		Where("django_relative_path = ? OR google_drive_download_link = ?", input.DjangoRelativePath, input.GoogleDriveDownloadLink).
+		Count(&count).Error
+	return count > 0, err
+}
+
+/* findResource loads a resource that has not been deleted, writing the error response if it cannot */
+func (rc *ResourceController) findResource(c *gin.Context, withContent bool) (*models.WebCrawlerResource, bool) {
+	resourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resource ID"})
+		return nil, false
+	}
+
+	query := rc.DB
+	if !withContent {
+		query = query.Omit("extracted_content")
+	}
+
+	var resource models.WebCrawlerResource
+	if err := query.First(&resource, "id = ?", resourceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch resource", "details": err.Error()})
+		return nil, false
+	}
+	return &resource, true
+}
+
+// CreateResource adds a resource. Its level, subject, type, term and year
+// are derived from the name and parent directory.
+//
+// Request Body:
+//   - models.ResourceInput; name, django_relative_path and
+//     google_drive_download_link are required.
+//
+// Responses:
+//   - 201 Created: {"message": string, "data": ResourceDetailResponse}
+//   - 400 Bad Request: Invalid input.
+//   - 409 Conflict: Another resource uses the path or Drive link.
+//   - 500 Internal Server Error: If the resource cannot be saved.
+func (rc *ResourceController) CreateResource(c *gin.Context) {
+	var input models.ResourceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	inUse, err := rc.resourceInUse(&input, uuid.Nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for duplicates", "details": err.Error()})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another resource already uses this django_relative_path or google_drive_download_link"})
+		return
+	}
+
+	var resource models.WebCrawlerResource
+	input.ApplyTo(&resource)
+	if err := rc.DB.Create(&resource).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create resource", "details": err.Error()})
+		return
+	}
+
+	/* Search suggestions pick up new words on the next import or restart */
+	resourceCache.Flush()
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Resource created", "data": newResourceDetail(&resource, "")})
+}
+
+// UpdateResource changes a resource's metadata. Fields left out of the body
+// keep their value. Unless the resource was recategorized by hand, its
+// labels follow the new name and parent directory.
+//
+// Request Body:
+//   - Any fields of models.ResourceInput.
+//
+// Responses:
+//   - 200 OK: {"message": string, "data": ResourceDetailResponse}
+//   - 400 Bad Request: Invalid resource ID or input.
+//   - 404 Not Found: No resource with this ID.
+//   - 409 Conflict: Another resource uses the path or Drive link.
+//   - 500 Internal Server Error: If the resource cannot be saved.
+func (rc *ResourceController) UpdateResource(c *gin.Context) {
+	resource, ok := rc.findResource(c, true)
+	if !ok {
+		return
+	}
+
+	input := models.NewResourceInput(resource)
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	inUse, err := rc.resourceInUse(&input, resource.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for duplicates", "details": err.Error()})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another resource already uses this django_relative_path or google_drive_download_link"})
+		return
+	}
+
+	input.ApplyTo(resource)
+	if err := rc.DB.Save(resource).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update resource", "details": err.Error()})
+		return
+	}
+
+	resourceCache.Flush()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Resource updated", "data": newResourceDetail(resource, "")})
+}
+
+// RecategorizeResource sets a resource's level, subject, type, term and year
+// by hand, for resources the classifier got wrong. Labels are normalised the
+// same way as filters ("maths" becomes "Mathematics"); an empty label or 0
+// clears it. Hand-set labels survive renames, imports and classifier updates
+// until "automatic" hands the resource back to the classifier.
+//
+// Request Body:
+//   - models.ResourceCategoriesInput; omitted fields keep their value.
+//
+// Responses:
+//   - 200 OK: {"message": string, "data": ResourceDetailResponse}
+//   - 400 Bad Request: Invalid resource ID, term or year.
+//   - 404 Not Found: No resource with this ID.
+//   - 500 Internal Server Error: If the resource cannot be saved.
+func (rc *ResourceController) RecategorizeResource(c *gin.Context) {
+	resource, ok := rc.findResource(c, false)
+	if !ok {
+		return
+	}
+
+	input := models.ResourceCategoriesInput{
+		Level:   resource.Level,
+		Subject: resource.Subject,
+		Type:    resource.ResourceType,
+		Term:    resource.Term,
+		Year:    resource.Year,
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	if input.Automatic {
+		resource.ManuallyClassified = false
+		resource.Classify()
+	} else {
+		if input.Term < 0 || input.Term > 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": "term must be 1, 2, 3 or 0 for none"})
+			return
+		}
+		if input.Year != 0 && (input.Year < 1990 || input.Year > time.Now().Year()+1) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": "year must be a school year or 0 for none"})
+			return
+		}
+
+		level := strings.TrimSpace(input.Level)
+		subject := strings.TrimSpace(input.Subject)
+		resourceType := strings.TrimSpace(input.Type)
+		resource.Level = canonicalLabel(level, utils.ClassifyResource(level, "").Level)
+		resource.Subject = canonicalLabel(subject, utils.ClassifyResource(subject, "").Subject)
+		resource.ResourceType = canonicalLabel(resourceType, utils.ClassifyResource(resourceType, "").Type)
+		resource.Term = input.Term
+		resource.Year = input.Year
+		resource.ManuallyClassified = true
+	}
+
+	/* BeforeSave recomputes the categories from the labels */
+	if err := rc.DB.Model(resource).
+		Select("level", "subject", "resource_type", "term", "year", "categories", "classifier_version", "manually_classified").
+		Updates(resource).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recategorize resource", "details": err.Error()})
+		return
+	}
+
+	resourceCache.Flush()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Resource recategorized", "data": newResourceDetail(resource, "")})
+}
+
+// DeleteResource soft-deletes a resource: it disappears from listings,
+// search and detail pages but stays in the table, and importing it again
+// restores it.
+//
+// Responses:
+//   - 200 OK: {"message": string}
+//   - 400 Bad Request: Invalid resource ID.
+//   - 404 Not Found: No resource with this ID.
+//   - 500 Internal Server Error: If the resource cannot be deleted.
+func (rc *ResourceController) DeleteResource(c *gin.Context) {
+	resourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resource ID"})
+		return
+	}
+
+	result := rc.DB.Delete(&models.WebCrawlerResource{}, "id = ?", resourceID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete resource", "details": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+		return
+	}
+
+	resourceCache.Flush()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Resource deleted"})
+}
+
+// ImportResources creates or updates resources in bulk from a CSV or JSONL
+// file, matching existing resources on django_relative_path (see
+// database.ImportResources). Invalid rows are skipped and listed in the
+// report.
+//
+// The file is sent as the multipart field "file", or as the raw request body
+// with the format given in the query.
+//
+// Query Parameters:
+//   - format (optional): "csv" or "jsonl"; defaults to the file extension.
+//   - dry_run (optional): "true" to report what would change without saving.
+//
+// Responses:
+//   - 200 OK: {"message": string, "data": database.ImportReport}
+//   - 400 Bad Request: No file, an unknown format or an unreadable file.
+//   - 500 Internal Server Error: If the import cannot be saved.
+func (rc *ResourceController) ImportResources(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	format := strings.ToLower(c.Query("format"))
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, resourceImportMaxBytes)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the file as the \"file\" field", "details": err.Error()})
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file", "details": err.Error()})
+			return
+		}
+		defer file.Close()
+
+		body = file
+		if format == "" {
+			format = database.ImportFormatFromName(fileHeader.Filename)
+		}
+	}
+
+	report, err := database.ImportResources(rc.DB, body, format, dryRun)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidImport) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import file", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import resources", "details": err.Error()})
+		return
+	}
+
+	message := "Resources imported"
+	if dryRun {
+		message = "Dry run; nothing was saved"
+	} else {
+		resourceCache.Flush()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "data": report})
+}
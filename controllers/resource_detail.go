@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/* Resource detail limits */
+const (
+	resourcePreviewChars   = 600   /* Length of the preview when there is no search text */
+	resourceHeadlineChars  = 50000 /* Extracted text scanned for highlighted fragments */
+	resourceSiblingLimit   = 50
+	resourceRelatedLimit   = 12
+	resourceHeadlineOption = `StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=3, FragmentDelimiter=" … "`
+)
+
+/* Escapes extracted text for HTML before ts_headline adds its <mark> tags */
+const escapedExtractedContent = `replace(replace(replace(left(coalesce(extracted_content, ''), ?), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`
+
+/* ResourceDetailResponse is a single resource with its metadata and a preview of its text */
+type ResourceDetailResponse struct {
+	ID                      uuid.UUID `json:"id"`
+	Name                    string    `json:"name"`
+	ParentURL               string    `json:"parent_url"`
+	ParentDirectory         string    `json:"parent_directory"`
+	RelativePath            string    `json:"relative_path"`
+	DjangoRelativePath      string    `json:"django_relative_path"`
+	GoogleCloudStorageLink  string    `json:"google_cloud_storage_link"`
+	GoogleDriveDownloadLink string    `json:"google_drive_download_link"`
+	CreatedAt               time.Time `json:"created_at"`
+	Categories              []string  `json:"categories"`
+	Level                   string    `json:"level"`
+	Subject                 string    `json:"subject"`
+	ResourceType            string    `json:"resource_type"`
+	Term                    int       `json:"term"`
+	Year                    int       `json:"year"`
+	ManuallyClassified      bool      `json:"manually_classified"`
+	IsExtracted             bool      `json:"is_extracted"`
+	Preview                 string    `json:"preview"` /* HTML-escaped; search matches are wrapped in <mark> */
+	IsLocked                bool      `json:"is_locked"`
+}
+
+/* newResourceDetail converts a resource to ResourceDetailResponse */
+func newResourceDetail(resource *models.WebCrawlerResource, preview string) ResourceDetailResponse {
+	return ResourceDetailResponse{
+		ID:                      resource.ID,
+		Name:                    resource.Name,
+		ParentURL:               resource.ParentURL,
+		ParentDirectory:         resource.ParentDirectory,
+		RelativePath:            resource.RelativePath,
+		DjangoRelativePath:      resource.DjangoRelativePath,
+		GoogleCloudStorageLink:  resource.GoogleCloudStorageLink,
+		GoogleDriveDownloadLink: resource.GoogleDriveDownloadLink,
+		CreatedAt:               resource.CreatedAt,
+		Categories:              append([]string{}, resource.Categories...),
+		Level:                   resource.Level,
+		Subject:                 resource.Subject,
+		ResourceType:            resource.ResourceType,
+		Term:                    resource.Term,
+		Year:                    resource.Year,
+		ManuallyClassified:      resource.ManuallyClassified,
+		IsExtracted:             resource.IsExtracted,
+		Preview:                 preview,
+	}
+}
+
+/* newResourceResponses converts list rows to ResourceResponse */
+func newResourceResponses(resources []models.WebCrawlerResource) []ResourceResponse {
+	response := make([]ResourceResponse, 0, len(resources))
+	for _, r := range resources {
+		response = append(response, ResourceResponse{
+			ID:                     r.ID,
+			Name:                   r.Name,
+			DjangoRelativePath:     r.DjangoRelativePath,
+			GoogleCloudStorageLink: r.GoogleCloudStorageLink,
+			CreatedAt:              r.CreatedAt,
+		})
+	}
+	return response
+}
+
+// gateResourceDetail hides the download links in a resource detail payload
+// from users without an active subscription: the resource's own links and
+// those of its siblings and related resources. Like gateResourceLinks it
+// copies the payload instead of modifying the cached one.
+func gateResourceDetail(c *gin.Context, payload gin.H) gin.H {
+	detail, ok := payload["data"].(ResourceDetailResponse)
+	if !ok || hasActiveSubscription(c) {
+		return payload
+	}
+
+	detail.GoogleCloudStorageLink = ""
+	detail.GoogleDriveDownloadLink = ""
+	detail.IsLocked = true
+
+	result := gin.H{}
+	for key, value := range payload {
+		result[key] = value
+	}
+	result["data"] = detail
+	for _, key := range []string{"siblings", "related"} {
+		if resources, ok := payload[key].([]ResourceResponse); ok {
+			locked := make([]ResourceResponse, len(resources))
+			copy(locked, resources)
+			for i := range locked {
+				locked[i].GoogleCloudStorageLink = ""
+				locked[i].IsLocked = true
+			}
+			result[key] = locked
+		}
+	}
+	return result
+}
+
+// resourcePreview returns a short, HTML-escaped extract of a resource's text.
+// With search text it is up to three fragments around the matches, which
+// ts_headline wraps in <mark>; otherwise it is the start of the text, cut at
+// a word boundary.
+func (rc *ResourceController) resourcePreview(id uuid.UUID, text string) (string, error) {
+	var preview string
+
+	if text = strings.TrimSpace(text); text != "" {
+		tsquery, vars := tsquerySQL(expandSearchTerms(text, rc.searchSynonyms()))
+		args := append([]interface{}{models.ResourceSearchConfig, resourceHeadlineChars}, vars...)
+		args = append(args, resourceHeadlineOption, id)
+		err := rc.DB.Raw(`SELECT ts_headline(?::regconfig, `+escapedExtractedContent+`, (`+tsquery+`), ?)
+			FROM web_crawler_resources WHERE id = ?`, args...).Scan(&preview).Error
+		return preview, err
+	}
+
+	if err := rc.DB.Raw(`SELECT `+escapedExtractedContent+` FROM web_crawler_resources WHERE id = ?`,
+		resourcePreviewChars+1, id).Scan(&preview).Error; err != nil {
+		return "", err
+	}
+	if len([]rune(preview)) > resourcePreviewChars {
+		preview = string([]rune(preview)[:resourcePreviewChars])
+		if cut := strings.LastIndexAny(preview, " \n\t"); cut > 0 {
+			preview = preview[:cut]
+		}
+		preview = strings.TrimSpace(preview) + " …"
+	}
+	return preview, nil
+}
+
+// relatedResources finds resources from other directories that share the
+// resource's subject or level, ranked by how much they have in common:
+// subject and level count most, then type and year, then recency.
+func (rc *ResourceController) relatedResources(resource *models.WebCrawlerResource) ([]models.WebCrawlerResource, error) {
+	var related []models.WebCrawlerResource
+	if resource.Subject == "" && resource.Level == "" {
+		return related, nil
+	}
+
+	score := `(CASE WHEN subject <> '' AND subject = ? THEN 4 ELSE 0 END +
+		CASE WHEN level <> '' AND level = ? THEN 4 ELSE 0 END +
+		CASE WHEN resource_type <> '' AND resource_type = ? THEN 2 ELSE 0 END +
+		CASE WHEN year <> 0 AND year = ? THEN 1 ELSE 0 END) DESC, created_at DESC`
+
+	err := rc.DB.Omit("extracted_content").
+		Where("id <> ? AND parent_directory <> ?", resource.ID, resource.ParentDirectory).
+		Where("(subject <> '' AND subject = ?) OR (level <> '' AND level = ?)", resource.Subject, resource.Level).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                score,
+			Vars:               []interface{}{resource.Subject, resource.Level, resource.ResourceType, resource.Year},
+			WithoutParentheses: true,
+		}}).
+		Limit(resourceRelatedLimit).
+		Find(&related).Error
+	return related, err
+}
+
+// GetResource returns one resource with its full metadata, a preview of its
+// extracted text, the other files in its directory and related resources
+// that share its subject or level. Download links are hidden from users
+// without an active subscription.
+//
+// Path Parameters:
+//   - id: The resource ID.
+//
+// Query Parameters:
+//   - q (optional): Search text to highlight in the preview, usually the
+//     search that led to the resource.
+//
+// Responses:
+//   - 200 OK: {"data": {...}, "siblings": [...], "related": [...]}
+//   - 400 Bad Request: Invalid resource ID.
+//   - 404 Not Found: No resource with this ID.
+//   - 500 Internal Server Error: If the resource cannot be loaded.
+func (rc *ResourceController) GetResource(c *gin.Context) {
+	resourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resource ID"})
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	cacheKey := "resource:" + resourceID.String() + "&q=" + q
+	if cachedData, found := resourceCache.Get(cacheKey); found {
+		c.JSON(http.StatusOK, gateResourceDetail(c, cachedData.(gin.H)))
+		return
+	}
+
+	var resource models.WebCrawlerResource
+	if err := rc.DB.Omit("extracted_content").First(&resource, "id = ?", resourceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch resource", "details": err.Error()})
+		return
+	}
+
+	preview, err := rc.resourcePreview(resource.ID, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build preview", "details": err.Error()})
+		return
+	}
+
+	var siblings []models.WebCrawlerResource
+	if err := rc.DB.Omit("extracted_content").
+		Where("parent_directory = ? AND id <> ?", resource.ParentDirectory, resource.ID).
+		Order("name").
+		Limit(resourceSiblingLimit).
+		Find(&siblings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sibling resources", "details": err.Error()})
+		return
+	}
+
+	related, err := rc.relatedResources(&resource)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch related resources", "details": err.Error()})
+		return
+	}
+
+	finalResponse := gin.H{
+		"data":     newResourceDetail(&resource, preview),
+		"siblings": newResourceResponses(siblings),
+		"related":  newResourceResponses(related),
+	}
+
+	resourceCache.Set(cacheKey, finalResponse, cache.DefaultExpiration)
+
+	c.JSON(http.StatusOK, gateResourceDetail(c, finalResponse))
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/patrickmn/go-cache"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ResourceController struct {
@@ -38,6 +39,39 @@ type ResourceResponse struct {
 	CreatedAt              time.Time `json:"created_at"`
 	// Categories []string `json:"categories"`
 	// IsExtracted bool `json:"is_extracted"`
+	IsLocked bool `json:"is_locked"` /* Download link hidden until the user subscribes */
+}
+
+// gateResourceLinks hides GoogleCloudStorageLink from users without an active
+// subscription. Free users keep the links of the first FREE_TIER_LINKS_PER_PAGE
+// resources on the page; the rest are returned with is_locked set to true.
+// The payload is copied rather than modified because it may live in the cache.
+//
+// Parameters:
+//   - c: The Gin context, used to read the flag set by middleware.SubscriptionStatus.
+//   - payload: The response whose "data" field holds []ResourceResponse.
+//
+// Returns:
+//   - gin.H: The payload to send to this user.
+func gateResourceLinks(c *gin.Context, payload gin.H) gin.H {
+	resources, ok := payload["data"].([]ResourceResponse)
+	if !ok || hasActiveSubscription(c) {
+		return payload
+	}
+
+	gated := make([]ResourceResponse, len(resources))
+	copy(gated, resources)
+	for i := freeTierLinkLimit(); i < len(gated); i++ {
+		gated[i].GoogleCloudStorageLink = ""
+		gated[i].IsLocked = true
+	}
+
+	result := gin.H{}
+	for key, value := range payload {
+		result[key] = value
+	}
+	result["data"] = gated
+	return result
 }
 
 // Helper function to add space after "form" or "grade" if followed by a number without space
@@ -57,23 +91,60 @@ func addSpaceAfterFormOrGrade(input string) string {
 	return input
 }
 
+// GetResources searches resources using Postgres full-text search and
+// returns them by relevance. The search text is matched against the name,
+// parent directory and extracted content, in that order of importance, and
+// understands web-style syntax: quoted phrases, "or" and -excluded words.
+// Abbreviations from the search_synonyms table ("cre", "f2") also match their
+// expansion. When nothing matches, misspelt words are corrected ("did_you_mean")
+// and then names and directories are compared by similarity; "match_type" in
+// the response says which of these found the results.
+//
+// Older clients send up to four terms as q1..q4 instead of q. All given terms
+// must match; if nothing does, the last terms are dropped one at a time, and
+// if still nothing matches every resource is listed. "parameters_used" in the
+// response says which terms were applied.
+//
+// Results can be narrowed by the level, subject, type, term and year derived
+// from each resource's name and directory (see utils.ClassifyResource).
+// "facets" counts the matching resources per value of each of these, so
+// clients can offer the next filters to choose from.
+//
+// Query Parameters:
+//   - q (optional): The search text.
+//   - q1..q4 (optional): Legacy search terms, ignored when q is given.
+//   - level, subject, type (optional): e.g. "Grade 4", "Mathematics", "Marking Scheme".
+//   - term, year (optional): e.g. 2, 2024.
+//   - page, limit (optional): Pagination; defaults to page 1 of 100.
+//
+// Responses:
+//   - 200 OK: {"data": [...], "pagination": {...}, "parameters_used": [...], "filters": {...}, "facets": {...}, "match_type": string, "did_you_mean": string}
+//   - 400 Bad Request: term or year is not a number.
+//   - 500 Internal Server Error: The search failed.
 func (rc *ResourceController) GetResources(c *gin.Context) {
 	var resources []models.WebCrawlerResource
 	var response []ResourceResponse
 
-	/* Generate a cache key based on search params and pagination */
+	filters, err := resourceFiltersFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter", "details": err.Error()})
+		return
+	}
+
+	/* Generate a cache key based on search params, filters and pagination */
 	searchParams := []string{"q1", "q2", "q3", "q4"}
-	cacheKey := "resources:"
+	cacheKey := "resources:q=" + c.Query("q") + "&"
 	for _, param := range searchParams {
 		cacheKey += param + "=" + c.Query(param) + "&"
 	}
+	cacheKey += filters.cacheKey() + "&"
 	cacheKey += "page=" + c.DefaultQuery("page", "1") + "&"
 	cacheKey += "limit=" + c.DefaultQuery("limit", "100")
 
 	/* Check if the result is already in the cache */
 	if cachedData, found := resourceCache.Get(cacheKey); found {
 		/* Return the cached response */
-		c.JSON(http.StatusOK, cachedData)
+		c.JSON(http.StatusOK, gateResourceLinks(c, cachedData.(gin.H)))
 		return
 	}
 
@@ -83,78 +154,86 @@ func (rc *ResourceController) GetResources(c *gin.Context) {
 	pageInt, _ := strconv.Atoi(page)
 	limitInt, _ := strconv.Atoi(limit)
 
-	/* Try different parameter combinations until we find results */
 	var finalQuery *gorm.DB
 	var totalRecords int64
 	var queryUsed []string // Track which parameters were used
+	var match *resourceMatch
 
-	// Try all parameters first, then fall back to fewer parameters if no results
-	for i := len(searchParams); i > 0; i-- {
-		query := rc.DB.Model(&models.WebCrawlerResource{})
-		queryUsed = searchParams[:i] // Current parameters being tried
-		hasConditions := false
-
-		// Apply all parameters up to the current index
-		for _, param := range queryUsed {
-			if value := c.Query(param); value != "" {
-				value = strings.ToLower(value)
-				// Apply form/grade transformation only for q1
-				if param == "q1" {
-					value = addSpaceAfterFormOrGrade(value)
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		/* A single search box: no dropping of terms, an empty result means nothing matched */
+		queryUsed = []string{"q"}
+		if match, err = rc.searchResources(addSpaceAfterFormOrGrade(q), filters); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search resources"})
+			return
+		}
+	} else {
+		// Try all parameters first, then fall back to fewer parameters if no results
+		for i := len(searchParams); i > 0; i-- {
+			queryUsed = searchParams[:i] // Current parameters being tried
+
+			/* Terms are joined into one query, so every term has to match */
+			var terms []string
+			for _, param := range queryUsed {
+				if value := strings.TrimSpace(c.Query(param)); value != "" {
+					// Apply form/grade transformation only for q1
+					if param == "q1" {
+						value = addSpaceAfterFormOrGrade(value)
+					}
+					terms = append(terms, value)
 				}
-				query = query.Where(
-					"LOWER(name) LIKE ? OR "+
-						"LOWER(parent_directory) LIKE ? OR "+
-						"LOWER(google_drive_download_link) LIKE ? OR "+
-						"LOWER(relative_path) LIKE ? OR "+
-						"LOWER(extracted_content) LIKE ? OR "+
-						"LOWER(google_cloud_storage_link) LIKE ?",
-					"%"+value+"%",
-					"%"+value+"%",
-					"%"+value+"%",
-					"%"+value+"%",
-					"%"+value+"%",
-					"%"+value+"%",
-				)
-				hasConditions = true
 			}
-		}
 
-		// If no conditions were applied (all params empty), break and use all records
-		if !hasConditions {
-			queryUsed = []string{} // No parameters used
-			break
-		}
+			// If no conditions were applied (all params empty), break and use all records
+			if len(terms) == 0 {
+				queryUsed = []string{} // No parameters used
+				break
+			}
 
-		// Count records for this query
-		var count int64
-		if err := query.Count(&count).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count resources"})
-			return
-		}
+			candidate, err := rc.searchResources(strings.Join(terms, " "), filters)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search resources"})
+				return
+			}
 
-		// If we found results, use this query
-		if count > 0 {
-			finalQuery = query
-			totalRecords = count
-			break
+			// If we found results, use this query
+			if candidate.total > 0 {
+				match = candidate
+				break
+			}
 		}
 	}
 
-	// If all parameter combinations returned 0 results, use base query (no conditions)
-	if finalQuery == nil {
-		finalQuery = rc.DB.Model(&models.WebCrawlerResource{})
+	if match != nil {
+		finalQuery = match.query
+		totalRecords = match.total
+	} else {
+		// If all parameter combinations returned 0 results, use base query (only the filters)
+		queryUsed = []string{}
+		finalQuery = filters.scope(rc.DB.Model(&models.WebCrawlerResource{}))
 		if err := finalQuery.Count(&totalRecords).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count resources"})
 			return
 		}
 	}
 
+	/* Facets are counted over every match, before ordering and pagination */
+	facets, err := countResourceFacets(finalQuery)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count resource facets"})
+		return
+	}
+
+	if match != nil {
+		finalQuery = finalQuery.Session(&gorm.Session{}).Order(clause.OrderBy{Expression: match.order})
+	} else {
+		finalQuery = finalQuery.Session(&gorm.Session{}).Order("created_at DESC")
+	}
+
 	/* Apply pagination */
 	finalQuery = finalQuery.Scopes(Paginate(page, limit))
 
-	/* Execute query */
-	if err := finalQuery.Find(&resources).Error; err != nil {
+	/* Execute query; extracted content is large and never returned */
+	if err := finalQuery.Omit("extracted_content").Find(&resources).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch resources"})
 		return
 	}
@@ -188,13 +267,21 @@ func (rc *ResourceController) GetResources(c *gin.Context) {
 			"limit":         limitInt,
 		},
 		"parameters_used": queryUsed, // Include which parameters were actually used
+		"filters":         filters.applied(),
+		"facets":          facets,
+	}
+	if match != nil {
+		finalResponse["match_type"] = match.matchType
+		if match.didYouMean != "" {
+			finalResponse["did_you_mean"] = match.didYouMean
+		}
 	}
 
 	/* Store the result in the cache */
 	resourceCache.Set(cacheKey, finalResponse, cache.DefaultExpiration)
 
 	/* Return the response */
-	c.JSON(http.StatusOK, finalResponse)
+	c.JSON(http.StatusOK, gateResourceLinks(c, finalResponse))
 }
 
 // func (rc *ResourceController) GetResources(c *gin.Context) {
@@ -310,6 +397,41 @@ func (rc *ResourceController) GetResources(c *gin.Context) {
 // 	c.JSON(http.StatusOK, finalResponse)
 // }
 
+/* Directory listing row returned by GetUniqeParentDirectories */
+type DirectoryResourceResponse struct {
+	ID                     uuid.UUID `json:"id"`
+	ParentDirectory        string    `json:"parent_directory"`
+	Name                   string    `json:"name"`
+	GoogleCloudStorageLink string    `json:"google_cloud_storage_link"`
+}
+
+// gateDirectoryLinks hides every GoogleCloudStorageLink in a directory listing
+// from users without an active subscription. Like gateResourceLinks it copies
+// the payload instead of modifying the cached one.
+func gateDirectoryLinks(c *gin.Context, payload gin.H) gin.H {
+	directories, ok := payload["data"].(map[string][]DirectoryResourceResponse)
+	if !ok || hasActiveSubscription(c) {
+		return payload
+	}
+
+	gated := make(map[string][]DirectoryResourceResponse, len(directories))
+	for dir, records := range directories {
+		locked := make([]DirectoryResourceResponse, len(records))
+		copy(locked, records)
+		for i := range locked {
+			locked[i].GoogleCloudStorageLink = ""
+		}
+		gated[dir] = locked
+	}
+
+	result := gin.H{}
+	for key, value := range payload {
+		result[key] = value
+	}
+	result["data"] = gated
+	return result
+}
+
 /* Pagination scope */
 func Paginate(page, limit string) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
@@ -336,7 +458,7 @@ func (rc *ResourceController) GetUniqeParentDirectories(c *gin.Context) {
     cacheKey := "unique_directories:search=" + search + "&page=" + page + "&limit=" + limit
 
     if cachedData, found := resourceCache.Get(cacheKey); found {
-        c.JSON(http.StatusOK, cachedData)
+        c.JSON(http.StatusOK, gateDirectoryLinks(c, cachedData.(gin.H)))
         return
     }
 
@@ -367,14 +489,7 @@ func (rc *ResourceController) GetUniqeParentDirectories(c *gin.Context) {
     }
 
     // Step 2: Get only the required fields for these directories
-    type ResourceResponse struct {
-        ID                     uuid.UUID `json:"id"`
-        ParentDirectory        string    `json:"parent_directory"`
-        Name                   string    `json:"name"`
-        GoogleCloudStorageLink string    `json:"google_cloud_storage_link"`
-    }
-    
-    var allRecords []ResourceResponse
+    var allRecords []DirectoryResourceResponse
     if err := rc.DB.Model(&models.WebCrawlerResource{}).
         Select("id", "parent_directory", "name", "google_cloud_storage_link").
         Where("parent_directory IN ?", directories).
@@ -385,7 +500,7 @@ func (rc *ResourceController) GetUniqeParentDirectories(c *gin.Context) {
 
     // Process the records
     prefix := "/home/bot-on-tapwater/projects/cbcexams/media/downloaded_files/"
-    directoryMap := make(map[string][]ResourceResponse)
+    directoryMap := make(map[string][]DirectoryResourceResponse)
 
     for _, record := range allRecords {
         trimmedDir := strings.TrimPrefix(record.ParentDirectory, prefix)
@@ -413,5 +528,5 @@ func (rc *ResourceController) GetUniqeParentDirectories(c *gin.Context) {
     }
 
     resourceCache.Set(cacheKey, finalResponse, cache.DefaultExpiration)
-    c.JSON(http.StatusOK, finalResponse)
+    c.JSON(http.StatusOK, gateDirectoryLinks(c, finalResponse))
 }
\ No newline at end of file
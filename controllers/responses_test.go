@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+/* Values stored in sensitive fields; none of them may appear in a response */
+const (
+	testPasswordHash   = "$2a$10$secret-password-hash"
+	testResetTokenHash = "secret-reset-token-hash"
+	testTOTPSecret     = "SECRETTOTPSECRET"
+	testRefreshHash    = "secret-refresh-token-hash"
+	testPreviousHash   = "secret-previous-refresh-token-hash"
+	testRecoveryHash   = "secret-recovery-code-hash"
+)
+
+func TestMain(m *testing.M) {
+	config.InitTimezone()
+	os.Exit(m.Run())
+}
+
+/* sensitiveKey reports whether a JSON key names a credential or account security state */
+func sensitiveKey(key string) bool {
+	switch key {
+	case "password", "failed_login_attempts", "locked_until", "refresh_token_hash", "previous_refresh_token_hash", "code_hash":
+		return true
+	}
+	return strings.HasPrefix(key, "password_reset") || strings.HasPrefix(key, "totp")
+}
+
+/* findSensitiveKeys returns the sensitive keys anywhere in decoded JSON */
+func findSensitiveKeys(value interface{}, path string) []string {
+	var found []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if sensitiveKey(key) {
+				found = append(found, path+"."+key)
+			}
+			found = append(found, findSensitiveKeys(child, path+"."+key)...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			found = append(found, findSensitiveKeys(child, path+"[]")...)
+		}
+	}
+	return found
+}
+
+// assertNoSecrets serializes a response body the way gin does and fails the
+// test if it has a sensitive key or any of the stored secret values.
+func assertNoSecrets(t *testing.T, name string, body interface{}) {
+	t.Helper()
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("%s: marshal: %v", name, err)
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("%s: unmarshal: %v", name, err)
+	}
+	if keys := findSensitiveKeys(decoded, name); len(keys) > 0 {
+		t.Errorf("%s exposes %v", name, keys)
+	}
+
+	for _, secret := range []string{testPasswordHash, testResetTokenHash, testTOTPSecret, testRefreshHash, testPreviousHash, testRecoveryHash} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("%s contains the secret value %q", name, secret)
+		}
+	}
+}
+
+/* testUser returns a user with every sensitive field set */
+func testUser() *models.User {
+	now := time.Now()
+	lockedUntil := now.Add(time.Hour)
+	return &models.User{
+		ID:                   uuid.New(),
+		Email:                "jane@example.com",
+		Password:             testPasswordHash,
+		FirstName:            "Jane",
+		LastName:             "Doe",
+		PhoneNumber:          "+254712345678",
+		PhoneVerifiedAt:      &now,
+		IsActive:             true,
+		EmailVerifiedAt:      &now,
+		Role:                 models.RoleSchool,
+		LastLogin:            now,
+		FailedLoginAttempts:  4,
+		LockedUntil:          &lockedUntil,
+		TOTPSecret:           testTOTPSecret,
+		TOTPEnabledAt:        &now,
+		TOTPLastStep:         123456,
+		PasswordResetToken:   testResetTokenHash,
+		PasswordResetExpires: now.Add(time.Hour),
+		DeletionScheduledFor: &now,
+	}
+}
+
+/* testSession returns a session with both refresh token hashes set */
+func testSession(userID uuid.UUID) *models.Session {
+	now := time.Now()
+	return &models.Session{
+		ID:                       uuid.New(),
+		UserID:                   userID,
+		RefreshTokenHash:         testRefreshHash,
+		PreviousRefreshTokenHash: testPreviousHash,
+		Device:                   "Phone",
+		IPAddress:                "127.0.0.1",
+		UserAgent:                "test",
+		LastSeenAt:               now,
+		ExpiresAt:                now.Add(time.Hour),
+		CreatedAt:                now,
+	}
+}
+
+/* dryRunDB returns a Postgres connection that builds queries without running them */
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("open dry-run database: %v", err)
+	}
+	return db
+}
+
+func TestUserModelHidesSecrets(t *testing.T) {
+	assertNoSecrets(t, "User", testUser())
+}
+
+func TestUserResponsesHideSecrets(t *testing.T) {
+	user := testUser()
+
+	assertNoSecrets(t, "UserResponse", user.Response())
+	assertNoSecrets(t, "AdminUserResponse", user.AdminResponse())
+	assertNoSecrets(t, "GetUsers", map[string]interface{}{"users": []models.AdminUserResponse{user.AdminResponse()}})
+
+	if !user.AdminResponse().Locked {
+		t.Error("AdminUserResponse does not report a locked account as locked")
+	}
+	if !user.Response().TwoFactorEnabled || !user.Response().HasPassword {
+		t.Error("UserResponse does not report two-factor or password status")
+	}
+}
+
+func TestBillingProfileHidesSecrets(t *testing.T) {
+	user := testUser()
+	assertNoSecrets(t, "BillingProfile", &models.BillingProfile{ID: uuid.New(), UserID: user.ID, Line1: "1 Moi Avenue", City: "Nairobi", CountryCode: "KE"})
+}
+
+func TestExportHidesSecrets(t *testing.T) {
+	user := testUser()
+
+	export, err := collectUserData(dryRunDB(t), user)
+	if err != nil {
+		t.Fatalf("collectUserData: %v", err)
+	}
+
+	/* The dry run finds nothing, so add the records a real export would hold */
+	export.Sessions = []models.Session{*testSession(user.ID)}
+	export.Identities = []models.UserIdentity{{ID: uuid.New(), UserID: user.ID, Provider: models.IdentityProviderGoogle, Subject: "google-subject", Email: user.Email, User: *user}}
+	export.Bookmarks = []models.Bookmark{{UserID: user.ID, User: *user}}
+	export.Subscriptions = []models.Subscription{{UserID: user.ID, PlanCode: "monthly", User: *user}}
+
+	assertNoSecrets(t, "export", export)
+
+	for _, file := range []struct {
+		name string
+		body interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"sessions.json", export.Sessions},
+		{"identities.json", export.Identities},
+	} {
+		assertNoSecrets(t, file.name, file.body)
+	}
+}
+
+func TestSessionResponsesHideSecrets(t *testing.T) {
+	previousSecret := utils.JWT_SECRET
+	utils.JWT_SECRET = "test-secret"
+	t.Cleanup(func() { utils.JWT_SECRET = previousSecret })
+
+	user := testUser()
+	session := testSession(user.ID)
+
+	tokens, err := sessionTokens(user, session, "refresh-token")
+	if err != nil {
+		t.Fatalf("sessionTokens: %v", err)
+	}
+	assertNoSecrets(t, "login", tokens)
+
+	assertNoSecrets(t, "GetSessions", map[string]interface{}{"data": []interface{}{sessionResponse(session, session.ID.String())}})
+	assertNoSecrets(t, "Session", session)
+}
+
+func TestTwoFactorResponsesHideSecrets(t *testing.T) {
+	previousSecret := utils.JWT_SECRET
+	utils.JWT_SECRET = "test-secret"
+	t.Cleanup(func() { utils.JWT_SECRET = previousSecret })
+
+	user := testUser()
+
+	challenge, err := twoFactorChallenge(user, "Phone")
+	if err != nil {
+		t.Fatalf("twoFactorChallenge: %v", err)
+	}
+	if challenge == nil {
+		t.Fatal("no two-factor challenge for a user with two-factor enabled")
+	}
+	assertNoSecrets(t, "two-factor challenge", challenge)
+
+	now := time.Now()
+	assertNoSecrets(t, "RecoveryCode", &models.RecoveryCode{ID: uuid.New(), UserID: user.ID, CodeHash: testRecoveryHash, CreatedAt: now, User: *user})
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/* How close (pg_trgm similarity, 0 to 1) a known word must be to a misspelt one to be suggested */
+const suggestionThreshold = 0.45
+
+/* Ways a search can match, reported as "match_type" */
+const (
+	matchFullText  = "full_text" /* The words (or their synonyms) were found */
+	matchCorrected = "corrected" /* Misspelt words were replaced; see "did_you_mean" */
+	matchFuzzy     = "fuzzy"     /* Names or directories resemble the search text */
+	matchNone      = "none"
+)
+
+/* A word glued to a number, like "f2" or "grd4" */
+var gluedNumberPattern = regexp.MustCompile(`^([a-z]+)(\d+)$`)
+
+/* resourceMatch is a search strategy's unpaginated query, its size and how to rank it */
+type resourceMatch struct {
+	query      *gorm.DB
+	total      int64
+	order      clause.Expression
+	matchType  string
+	didYouMean string
+}
+
+/* searchSynonyms returns the synonym table as term → expansion, cached like resource listings */
+func (rc *ResourceController) searchSynonyms() map[string]string {
+	if cached, found := resourceCache.Get("search_synonyms"); found {
+		return cached.(map[string]string)
+	}
+
+	synonyms := make(map[string]string)
+	var rows []models.SearchSynonym
+	if err := rc.DB.Find(&rows).Error; err != nil {
+		log.Printf("Failed to load search synonyms: %v", err)
+		return synonyms
+	}
+	for _, row := range rows {
+		synonyms[strings.ToLower(row.Term)] = strings.ToLower(row.Expansion)
+	}
+
+	resourceCache.Set("search_synonyms", synonyms, 10*time.Minute)
+	return synonyms
+}
+
+/* hasSearchSyntax reports whether text uses quotes, "or" or -exclusions, which are passed to Postgres untouched */
+func hasSearchSyntax(text string) bool {
+	if strings.Contains(text, `"`) {
+		return true
+	}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if word == "or" || strings.HasPrefix(word, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandSearchTerms splits search text into terms that must all match. A
+// term is the words the user typed plus, if they are a synonym, the expansion
+// as an alternative. Synonyms of up to three words are matched, longest
+// first, and a synonym glued to a number is split ("f2" also tries "form 2").
+// Text using search syntax is kept whole.
+func expandSearchTerms(text string, synonyms map[string]string) [][]string {
+	if hasSearchSyntax(text) {
+		return [][]string{{text}}
+	}
+
+	words := strings.Fields(strings.ToLower(text))
+	var terms [][]string
+	for i := 0; i < len(words); {
+		matched := 0
+		for n := 3; n >= 1 && matched == 0; n-- {
+			if i+n > len(words) {
+				continue
+			}
+			phrase := strings.Join(words[i:i+n], " ")
+			if expansion, ok := synonyms[phrase]; ok {
+				terms = append(terms, []string{phrase, expansion})
+				matched = n
+			}
+		}
+		if matched > 0 {
+			i += matched
+			continue
+		}
+
+		word := words[i]
+		if m := gluedNumberPattern.FindStringSubmatch(word); m != nil {
+			if expansion, ok := synonyms[m[1]]; ok {
+				terms = append(terms, []string{word, expansion + " " + m[2]})
+				i++
+				continue
+			}
+		}
+		terms = append(terms, []string{word})
+		i++
+	}
+	return terms
+}
+
+/* tsquerySQL builds a tsquery that requires every term, accepting any of a term's alternatives */
+func tsquerySQL(terms [][]string) (string, []interface{}) {
+	groups := make([]string, 0, len(terms))
+	var vars []interface{}
+	for _, alternatives := range terms {
+		parts := make([]string, 0, len(alternatives))
+		for _, alternative := range alternatives {
+			parts = append(parts, "websearch_to_tsquery(?::regconfig, ?)")
+			vars = append(vars, models.ResourceSearchConfig, alternative)
+		}
+		groups = append(groups, "("+strings.Join(parts, " || ")+")")
+	}
+	return strings.Join(groups, " && "), vars
+}
+
+/* fullTextMatch searches the weighted search vector, expanding synonyms, ranked with ts_rank */
+func (rc *ResourceController) fullTextMatch(text string, filters resourceFilters) (*resourceMatch, error) {
+	tsquery, vars := tsquerySQL(expandSearchTerms(text, rc.searchSynonyms()))
+	match := &resourceMatch{
+		query:     filters.scope(rc.DB.Model(&models.WebCrawlerResource{})).Where("search_vector @@ ("+tsquery+")", vars...),
+		order:     clause.Expr{SQL: "ts_rank(search_vector, " + tsquery + ") DESC, created_at DESC", Vars: vars, WithoutParentheses: true},
+		matchType: matchFullText,
+	}
+	return match, match.query.Count(&match.total).Error
+}
+
+// fuzzyMatch finds names or directories that resemble the search text, most
+// similar first. The <% operator matches when the word similarity reaches
+// config.WordSimilarityThreshold, which every connection sets, and is served
+// by the trigram indexes on lower(name) and lower(parent_directory).
+func (rc *ResourceController) fuzzyMatch(text string, filters resourceFilters) (*resourceMatch, error) {
+	text = strings.ToLower(text)
+	score := "GREATEST(word_similarity(?, lower(name)), word_similarity(?, lower(parent_directory)))"
+	match := &resourceMatch{
+		query:     filters.scope(rc.DB.Model(&models.WebCrawlerResource{})).Where("? <% lower(name) OR ? <% lower(parent_directory)", text, text),
+		order:     clause.Expr{SQL: score + " DESC, created_at DESC", Vars: []interface{}{text, text}, WithoutParentheses: true},
+		matchType: matchFuzzy,
+	}
+	return match, match.query.Count(&match.total).Error
+}
+
+// suggestCorrection replaces search words that appear in no resource name or
+// directory with the most similar word that does, using the vocabulary built
+// by database.RefreshSearchVocabulary. Short words, numbers and synonyms are
+// left alone.
+//
+// Returns:
+//   - string: The corrected search text, or "" if nothing was corrected.
+func (rc *ResourceController) suggestCorrection(text string) string {
+	if hasSearchSyntax(text) {
+		return ""
+	}
+
+	synonyms := rc.searchSynonyms()
+	words := strings.Fields(strings.ToLower(text))
+	corrected := false
+	for i, word := range words {
+		if len(word) < 4 || strings.ContainsAny(word, "0123456789") || synonyms[word] != "" {
+			continue
+		}
+
+		/* A known word ranks first and means there is nothing to correct */
+		var best string
+		if err := rc.DB.Raw(`SELECT word FROM resource_search_words
+			WHERE similarity(word, ?) >= ?
+			ORDER BY word = ? DESC, similarity(word, ?) DESC, ndoc DESC
+			LIMIT 1`, word, suggestionThreshold, word, word).Scan(&best).Error; err != nil {
+			log.Printf("Failed to suggest a correction for %q: %v", word, err)
+			return ""
+		}
+		if best != "" && best != word {
+			words[i] = best
+			corrected = true
+		}
+	}
+
+	if !corrected {
+		return ""
+	}
+	return strings.Join(words, " ")
+}
+
+// searchResources finds resources for a search text, trying more forgiving
+// strategies until one matches: full-text search with synonyms, the same
+// with misspelt words corrected, then trigram similarity on names and
+// directories. Only resources passing the filters are considered.
+//
+// Returns:
+//   - *resourceMatch: The first strategy with results, or the full-text
+//     match with a total of 0 if none had any.
+//   - error: An error if a query failed.
+func (rc *ResourceController) searchResources(text string, filters resourceFilters) (*resourceMatch, error) {
+	match, err := rc.fullTextMatch(text, filters)
+	if err != nil || match.total > 0 {
+		return match, err
+	}
+
+	if suggestion := rc.suggestCorrection(text); suggestion != "" {
+		corrected, err := rc.fullTextMatch(suggestion, filters)
+		if err != nil {
+			return nil, err
+		}
+		if corrected.total > 0 {
+			corrected.matchType = matchCorrected
+			corrected.didYouMean = suggestion
+			return corrected, nil
+		}
+	}
+
+	fuzzy, err := rc.fuzzyMatch(text, filters)
+	if err != nil {
+		return nil, err
+	}
+	if fuzzy.total > 0 {
+		return fuzzy, nil
+	}
+
+	match.matchType = matchNone
+	return match, nil
+}
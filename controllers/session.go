@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+refreshTokenTTL returns how long a session lasts without being refreshed.
+Configured with REFRESH_TOKEN_TTL_DAYS (default 30).
+*/
+func refreshTokenTTL() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_TTL_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// issueSession starts a new session for a user who has just proved who they
+// are, and returns the token pair the client should store. A pending account
+// deletion is cancelled.
+//
+// Parameters:
+//   - db: The database connection.
+//   - c: The request the login arrived on; its IP and user agent are recorded.
+//   - user: The authenticated user.
+//   - device: Optional device name supplied by the client.
+//
+// Returns:
+//   - gin.H: The response body with the access and refresh tokens.
+//   - error: An error if the session or token cannot be created.
+func issueSession(db *gorm.DB, c *gin.Context, user *models.User, device string) (gin.H, error) {
+	refreshToken := utils.GenerateRandomToken(32)
+
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		Device:           device,
+		IPAddress:        c.ClientIP(),
+		UserAgent:        c.Request.UserAgent(),
+		ExpiresAt:        time.Now().In(config.EAT).Add(refreshTokenTTL()),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	/* Logging in during the grace period keeps the account */
+	cancelAccountDeletion(db, user)
+
+	return sessionTokens(user, &session, refreshToken)
+}
+
+/* sessionTokens builds the token response for a session and its current refresh token */
+func sessionTokens(user *models.User, session *models.Session, refreshToken string) (gin.H, error) {
+	accessToken, err := utils.GenerateJWT(user.ID, user.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         accessToken, /* Kept for clients that predate refresh tokens */
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+		"session_id":    session.ID,
+		"role":          user.Role,
+	}, nil
+}
+
+/* completeLogin starts a session for an authenticated user and writes the token response */
+func completeLogin(c *gin.Context, db *gorm.DB, user *models.User, device string) {
+	tokens, err := issueSession(db, c, user, device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// revokeUserSessions revokes every active session of a user, optionally
+// keeping one (e.g. the session that asked for the others to be revoked).
+func revokeUserSessions(db *gorm.DB, userID uuid.UUID, keep *uuid.UUID) error {
+	query := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if keep != nil {
+		query = query.Where("id <> ?", *keep)
+	}
+	return query.Update("revoked_at", time.Now().In(config.EAT)).Error
+}
+
+// Refresh exchanges a refresh token for a new access token. The refresh token
+// is rotated: the one presented stops working and a new one is returned.
+// Presenting a refresh token that has already been rotated means it was
+// copied, so the whole session is revoked.
+//
+// Possible Responses:
+// - 200 OK: A new access token and refresh token.
+// - 400 Bad Request: The refresh token is missing.
+// - 401 Unauthorized: The refresh token is invalid, expired, revoked or reused.
+func (ac *AuthController) Refresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash := utils.HashToken(input.RefreshToken)
+	now := time.Now().In(config.EAT)
+
+	var session models.Session
+	if err := ac.DB.Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		/* A rotated token being replayed: revoke the session it belonged to */
+		if ac.DB.Where("previous_refresh_token_hash = ?", hash).First(&session).Error == nil {
+			ac.DB.Model(&session).Update("revoked_at", now)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if !session.IsActive(now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+		return
+	}
+
+	var user models.User
+	if err := ac.DB.First(&user, "id = ?", session.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	/* Rotate the refresh token; the update only wins if nobody else rotated it first */
+	refreshToken := utils.GenerateRandomToken(32)
+	result := ac.DB.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":          utils.HashToken(refreshToken),
+			"previous_refresh_token_hash": hash,
+			"last_seen_at":                now,
+			"ip_address":                  c.ClientIP(),
+			"user_agent":                  c.Request.UserAgent(),
+			"expires_at":                  now.Add(refreshTokenTTL()),
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	tokens, err := sessionTokens(&user, &session, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+/* sessionResponse is what the session list shows of a session; token hashes are left out */
+func sessionResponse(session *models.Session, currentID string) gin.H {
+	return gin.H{
+		"id":           session.ID,
+		"device":       session.Device,
+		"ip_address":   session.IPAddress,
+		"user_agent":   session.UserAgent,
+		"last_seen_at": session.LastSeenAt,
+		"created_at":   session.CreatedAt,
+		"current":      session.ID.String() == currentID,
+	}
+}
+
+// GetSessions lists the logged-in user's active sessions, most recently used
+// first. The session making the request is flagged with "current".
+//
+// Response:
+//   - HTTP 200 OK: {"data": [...]}.
+//   - HTTP 500 Internal Server Error: If the sessions cannot be fetched.
+func (ac *AuthController) GetSessions(c *gin.Context) {
+	userID, ok := utils.ParseUserIDFromString(c, c.GetString("user_id"))
+	if !ok {
+		return
+	}
+
+	var sessions []models.Session
+	if err := ac.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	currentID := c.GetString("session_id")
+	data := make([]gin.H, len(sessions))
+	for i := range sessions {
+		data[i] = sessionResponse(&sessions[i], currentID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// RevokeSession logs one of the user's devices out.
+//
+// Responses:
+//   - 200 OK: The session was revoked.
+//   - 400 Bad Request: Invalid session ID.
+//   - 404 Not Found: The user has no active session with this ID.
+func (ac *AuthController) RevokeSession(c *gin.Context) {
+	userID, ok := utils.ParseUserIDFromString(c, c.GetString("user_id"))
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	result := ac.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now().In(config.EAT))
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SubscriptionController struct {
+	DB *gorm.DB
+}
+
+// GetPlans returns the premium subscription plans on sale, cheapest first.
+//
+// Response:
+//   - HTTP 200 OK: A JSON object with the list of plans in the "data" field.
+func (sc *SubscriptionController) GetPlans(c *gin.Context) {
+	plans := make([]models.SubscriptionPlan, 0, len(models.SubscriptionPlans))
+	for _, plan := range models.SubscriptionPlans {
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Price < plans[j].Price })
+
+	c.JSON(http.StatusOK, gin.H{"data": plans})
+}
+
+// GetMySubscription returns the current user's active subscription (if any)
+// together with their full subscription history, newest first.
+//
+// Responses:
+//   - 200 OK: {"active": <subscription or null>, "history": [...]}.
+//   - 401 Unauthorized: If the user ID in the token is invalid.
+//   - 500 Internal Server Error: If the subscriptions cannot be fetched.
+func (sc *SubscriptionController) GetMySubscription(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	var subscriptions []models.Subscription
+	if err := sc.DB.Where("user_id = ?", userID).Order("expires_at DESC").Find(&subscriptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+
+	var active *models.Subscription
+	now := time.Now()
+	for i := range subscriptions {
+		if subscriptions[i].IsActive(now) {
+			active = &subscriptions[i]
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"active": active, "history": subscriptions})
+}
+
+// activateSubscription grants the plan bought with a completed order.
+// A user who still has time left on an earlier subscription gets the new
+// period appended to it rather than running both at once. Calling it twice
+// for the same order is a no-op.
+//
+// Access is only granted if both the order and the payment Pesapal verified
+// cover the plan's current price in the plan's currency.
+//
+// Parameters:
+//   - tx: The database transaction the order update runs in.
+//   - order: The order that has just completed.
+//   - statusResp: The verified status that completed the order.
+//
+// Returns:
+//   - error: errTransactionMismatch if the payment does not cover the plan,
+//     or an error if the subscription cannot be stored.
+func activateSubscription(tx *gorm.DB, order *models.Order, statusResp *pesapal.TransactionStatusResponse) error {
+	plan, ok := models.SubscriptionPlans[order.PlanCode]
+	if !ok || order.UserID == nil {
+		return nil
+	}
+
+	for _, paid := range []struct {
+		amount   float64
+		currency string
+	}{{order.Amount, order.Currency}, {statusResp.Amount, statusResp.Currency}} {
+		if paid.amount < plan.Price-0.005 || !strings.EqualFold(paid.currency, plan.Currency) {
+			return fmt.Errorf("%w: paid %.2f %s for the %s plan at %.2f %s",
+				errTransactionMismatch, paid.amount, paid.currency, plan.Code, plan.Price, plan.Currency)
+		}
+	}
+
+	var existing int64
+	if err := tx.Model(&models.Subscription{}).Where("order_id = ?", order.ID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	/* Start after the latest subscription that is still running */
+	startsAt := time.Now().In(config.EAT)
+	var latest models.Subscription
+	err := tx.Where("user_id = ? AND status = ? AND expires_at > ?", *order.UserID, models.SubscriptionStatusActive, startsAt).
+		Order("expires_at DESC").First(&latest).Error
+	if err == nil {
+		startsAt = latest.ExpiresAt
+	}
+
+	return tx.Create(&models.Subscription{
+		UserID:    *order.UserID,
+		OrderID:   order.ID,
+		PlanCode:  plan.Code,
+		Status:    models.SubscriptionStatusActive,
+		AutoRenew: plan.Recurring,
+		StartsAt:  startsAt,
+		ExpiresAt: plan.ExpiresAfter(startsAt),
+	}).Error
+}
+
+// revokeSubscription withdraws the access granted by an order whose payment
+// was reversed.
+func revokeSubscription(tx *gorm.DB, order *models.Order) error {
+	return tx.Model(&models.Subscription{}).
+		Where("order_id = ?", order.ID).
+		Update("status", models.SubscriptionStatusRevoked).Error
+}
+
+/*
+freeTierLinkLimit returns how many download links per page users without a
+subscription may see. Configured with FREE_TIER_LINKS_PER_PAGE (default 0).
+*/
+func freeTierLinkLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("FREE_TIER_LINKS_PER_PAGE"))
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+/* hasActiveSubscription reads the flag set by middleware.SubscriptionStatus */
+func hasActiveSubscription(c *gin.Context) bool {
+	return c.GetBool("has_active_subscription")
+}
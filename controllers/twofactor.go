@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/* Number of recovery codes issued at a time */
+const recoveryCodeCount = 10
+
+/* totpIssuer is the service name shown in authenticator apps; set with TOTP_ISSUER */
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "CBC Exams"
+}
+
+/* twoFactorChallenge returns the second-step response for a user with 2FA enabled, or nil if they have none */
+func twoFactorChallenge(user *models.User, device string) (gin.H, error) {
+	if user.TOTPEnabledAt == nil {
+		return nil, nil
+	}
+
+	token, err := utils.GenerateTwoFactorToken(user.ID, device)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"two_factor_required": true,
+		"two_factor_token":    token,
+		"expires_in":          int(utils.TwoFactorTokenTTL.Seconds()),
+	}, nil
+}
+
+/* beginLogin finishes a first login step: it asks for a second factor if the user has one, otherwise logs them in */
+func beginLogin(c *gin.Context, db *gorm.DB, user *models.User, device string) {
+	challenge, err := twoFactorChallenge(user, device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	completeLogin(c, db, user, device)
+}
+
+/* normalizeRecoveryCode strips the separator, spaces and case from a recovery code before hashing */
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+/* isTOTPCode reports whether code looks like an authenticator code rather than a recovery code */
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != utils.TOTPDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// verifySecondFactor checks an authenticator code or an unused recovery code
+// for a user with 2FA enabled. Each authenticator code is accepted once and
+// each recovery code is used up, even when two requests race.
+//
+// Returns:
+//   - bool: Whether the code was accepted.
+//   - error: An error if the database could not be updated.
+func verifySecondFactor(db *gorm.DB, user *models.User, code string) (bool, error) {
+	if user.TOTPEnabledAt == nil || user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	if isTOTPCode(code) {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			UpdateColumn("totp_last_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now().In(config.EAT))
+	return result.RowsAffected == 1, result.Error
+}
+
+// replaceRecoveryCodes discards a user's recovery codes and stores a new set.
+//
+// Returns:
+//   - []string: The new codes, formatted for display. They cannot be shown again.
+//   - error: An error if the codes could not be stored.
+func replaceRecoveryCodes(tx *gorm.DB, user *models.User) ([]string, error) {
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw := utils.GenerateRandomToken(5)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		records[i] = models.RecoveryCode{UserID: user.ID, CodeHash: utils.HashToken(raw)}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+/* currentUser loads the authenticated user, writing an error response if that fails */
+func (ac *AuthController) currentUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := ac.DB.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// GetTwoFactorStatus reports whether the authenticated user has two-factor
+// authentication enabled and how many recovery codes they have left.
+//
+// Possible Responses:
+// - 200 OK: {"enabled": bool, "enabled_at": time, "recovery_codes_remaining": int}
+// - 404 Not Found: The user no longer exists.
+func (ac *AuthController) GetTwoFactorStatus(c *gin.Context) {
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var remaining int64
+	ac.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TOTPEnabledAt != nil,
+		"enabled_at":               user.TOTPEnabledAt,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor starts TOTP enrollment. It creates a new secret and returns
+// it with the otpauth:// URI to show as a QR code. Two-factor authentication
+// is not enforced until the user confirms a code with ConfirmTwoFactor;
+// calling this again before then replaces the secret.
+//
+// Possible Responses:
+// - 200 OK: {"secret": string, "otpauth_uri": string}
+// - 404 Not Found: The user no longer exists.
+// - 409 Conflict: Two-factor authentication is already enabled.
+// - 500 Internal Server Error: The secret could not be stored.
+func (ac *AuthController) SetupTwoFactor(c *gin.Context) {
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate secret"})
+		return
+	}
+	if err := ac.DB.Model(user).UpdateColumn("totp_secret", secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save secret", "details": err.Error()})
+		return
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.PhoneNumber
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(totpIssuer(), account, secret),
+	})
+}
+
+// ConfirmTwoFactor finishes enrollment with a code from the authenticator
+// app, turns on two-factor authentication and returns a set of recovery
+// codes. The codes are only shown in this response.
+//
+// Request Body:
+//   - code (required): The current code from the authenticator app.
+//
+// Possible Responses:
+// - 200 OK: {"message": string, "recovery_codes": []string}
+// - 400 Bad Request: Enrollment was not started or the code is wrong.
+// - 409 Conflict: Two-factor authentication is already enabled.
+// - 500 Internal Server Error: The change could not be saved.
+func (ac *AuthController) ConfirmTwoFactor(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+		return
+	}
+
+	step, valid := utils.ValidateTOTP(user.TOTPSecret, input.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"totp_enabled_at": time.Now().In(config.EAT),
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not enable two-factor authentication", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled. Store these recovery codes somewhere safe.",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes.
+// The old codes stop working.
+//
+// Request Body:
+//   - code (required): The current code from the authenticator app.
+//
+// Possible Responses:
+// - 200 OK: {"recovery_codes": []string}
+// - 400 Bad Request: Two-factor authentication is not enabled or the code is wrong.
+// - 500 Internal Server Error: The codes could not be stored.
+func (ac *AuthController) RegenerateRecoveryCodes(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	/* Only an authenticator code will do; a recovery code cannot mint new ones */
+	if !isTOTPCode(input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+	valid, err := verifySecondFactor(ac.DB, user, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify code", "details": err.Error()})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate recovery codes", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns off two-factor authentication for the authenticated
+// user and deletes their secret and recovery codes.
+//
+// Request Body:
+//   - code (required): A code from the authenticator app or a recovery code.
+//
+// Possible Responses:
+// - 200 OK: Two-factor authentication was disabled.
+// - 400 Bad Request: Two-factor authentication is not enabled or the code is wrong.
+// - 500 Internal Server Error: The change could not be saved.
+func (ac *AuthController) DisableTwoFactor(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	valid, err := verifySecondFactor(ac.DB, user, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify code", "details": err.Error()})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not disable two-factor authentication", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// VerifyTwoFactor is the second login step for accounts with two-factor
+// authentication. It exchanges the token returned by Login for the usual
+// access and refresh tokens once a valid code is given. Wrong codes count
+// towards the same lockout as wrong passwords.
+//
+// Request Body:
+//   - two_factor_token (required): The token from the first login step.
+//   - code (required): A code from the authenticator app or a recovery code.
+//
+// Possible Responses:
+// - 200 OK: Access and refresh tokens.
+// - 400 Bad Request: Missing fields.
+// - 401 Unauthorized: The token is invalid or expired, or the code is wrong.
+// - 429 Too Many Requests: Too many failed attempts from this IP.
+func (ac *AuthController) VerifyTwoFactor(c *gin.Context) {
+	var input struct {
+		TwoFactorToken string `json:"two_factor_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ip := c.ClientIP()
+	if ipLoginBlocked(ip) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts. Try again later."})
+		return
+	}
+
+	userID, device, err := utils.ParseTwoFactorToken(input.TwoFactorToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	var user models.User
+	if err := ac.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	invalid := func(failures int) {
+		recordIPLoginFailure(ip)
+		time.Sleep(failureDelay(failures))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+	}
+
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		invalid(user.FailedLoginAttempts)
+		return
+	}
+
+	valid, err := verifySecondFactor(ac.DB, &user, input.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify code"})
+		return
+	}
+	if !valid {
+		invalid(recordLoginFailure(ac.DB, &user))
+		return
+	}
+	resetLoginFailures(ac.DB, &user)
+
+	completeLogin(c, ac.DB, &user, device)
+}
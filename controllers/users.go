@@ -2,11 +2,15 @@ package controllers
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/bot-on-tapwater/cbcexams-backend/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -57,7 +61,7 @@ func (uc *UsersController) Profile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	c.JSON(http.StatusOK, gin.H{"user": user.Response()})
 }
 
 // UpdateProfile handles the HTTP request to update a user's profile.
@@ -70,11 +74,15 @@ func (uc *UsersController) Profile(c *gin.Context) {
 // 6. Saves the updated user record back to the database, ensuring email uniqueness.
 // 7. Returns a success response with the updated user details or an appropriate error response.
 //
+// A new email address is kept as pending_email and a confirmation link is sent
+// to it; the account keeps its current, verified address until the link is
+// opened (see AuthController.VerifyEmail).
+//
 // @param c *gin.Context - The Gin context containing the HTTP request and response.
 // @response 200 OK - Profile updated successfully with updated user details.
 // @response 400 Bad Request - Invalid user ID format or input payload.
 // @response 404 Not Found - User not found in the database.
-// @response 409 Conflict - Email already exists in the database.
+// @response 409 Conflict - Another account uses the email address.
 func (uc *UsersController) UpdateProfile(c *gin.Context) {
 	/* Get user ID from JWT middleware */
 	userID, ok := c.MustGet("user_id").(string)
@@ -105,27 +113,149 @@ func (uc *UsersController) UpdateProfile(c *gin.Context) {
 	}
 
 	/* Apply updates */
+	previousPendingEmail := user.PendingEmail
 	if err := user.UpdateProfile(input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	/* The unique index only covers confirmed addresses, so check the pending one here */
+	if user.PendingEmail != "" && user.PendingEmail != previousPendingEmail {
+		var taken int64
+		if err := uc.DB.Model(&models.User{}).Where("email = ? AND id <> ?", user.PendingEmail, user.ID).Count(&taken).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile", "details": err.Error()})
+			return
+		}
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email alread exists"})
+			return
+		}
+	}
+
 	/* Save to DB */
 	if err := uc.DB.Save(&user).Error; err != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "Email alread exists"}) /* Handle email uniqueness */
 		return
 	}
 
+	/* A new email address takes effect once its link is opened */
+	message := "Profile updated"
+	if user.PendingEmail != "" && user.PendingEmail != previousPendingEmail {
+		if err := sendEmailChangeEmail(&user); err != nil {
+			log.Printf("Failed to send email change confirmation to %s: %v", user.PendingEmail, err)
+		}
+		message = "Profile updated. Open the link sent to your new email address to start using it."
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Profile updated",
+		"message": message,
 		"user": gin.H{
-			"firstName": user.FirstName,
-			"lastName":  user.LastName,
-			"email":     user.Email,
+			"firstName":    user.FirstName,
+			"lastName":     user.LastName,
+			"email":        user.Email,
+			"pendingEmail": user.PendingEmail,
+			"phoneNumber":  user.PhoneNumber,
 		},
 	})
 }
 
+/* How recently a session must have logged in to set an account's first password */
+const firstPasswordLoginWindow = 10 * time.Minute
+
+// ChangePassword sets a new password for the logged-in user. The current
+// password is required unless the account has none yet (phone or Google
+// sign-ups); setting a first password instead requires a session that logged
+// in within the last firstPasswordLoginWindow, so a stolen long-lived session
+// cannot add a password to the account. Every other session is logged out and
+// any pending reset link stops working; the session making the change stays
+// logged in.
+//
+// Request Body:
+//   - current_password: The existing password; required if one is set.
+//   - new_password (required): Must meet the password policy.
+//
+// Responses:
+//   - 200 OK: The password was changed.
+//   - 400 Bad Request: Missing fields, or the new password is too weak or unchanged.
+//   - 401 Unauthorized: The current password is wrong, or the session is too old to set a first password.
+//   - 404 Not Found: The user no longer exists.
+//   - 429 Too Many Requests: The account is locked after too many wrong passwords.
+//   - 500 Internal Server Error: If the password cannot be saved.
+func (uc *UsersController) ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	/* Wrong guesses count towards the login lockout, so a stolen token cannot brute-force the password */
+	if user.Password != "" {
+		if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts. Try again later."})
+			return
+		}
+		if err := user.CheckPassword(input.CurrentPassword); err != nil {
+			recordLoginFailure(uc.DB, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+		if input.NewPassword == input.CurrentPassword {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "New password must be different from the current one"})
+			return
+		}
+	} else {
+		/* With no password to confirm, the OTP or Google login that started this session is the proof */
+		var session models.Session
+		if err := uc.DB.First(&session, "id = ? AND user_id = ?", c.GetString("session_id"), user.ID).Error; err != nil ||
+			time.Since(session.CreatedAt) > firstPasswordLoginWindow {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Log in again to set a password", "reauthentication_required": true})
+			return
+		}
+	}
+
+	if err := utils.ValidatePassword(input.NewPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user.Password = input.NewPassword
+	if err := user.HashPassword(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := uc.DB.Model(&user).UpdateColumns(map[string]interface{}{
+		"password":               user.Password,
+		"password_reset_token":   "",
+		"password_reset_expires": time.Time{},
+		"failed_login_attempts":  0,
+		"locked_until":           nil,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password", "details": err.Error()})
+		return
+	}
+
+	/* Keep the session that made the change; log out everywhere else */
+	var keep *uuid.UUID
+	if sessionID, err := uuid.Parse(c.GetString("session_id")); err == nil {
+		keep = &sessionID
+	}
+	if err := revokeUserSessions(uc.DB, user.ID, keep); err != nil {
+		log.Printf("Failed to revoke sessions after password change for %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed. Other devices have been logged out."})
+}
+
 // GetUsers handles the retrieval of user data based on the provided query parameters.
 // If a "user_id" query parameter is provided, it fetches and returns the specific user
 // corresponding to that ID. If no "user_id" is provided, it fetches and returns all users.
@@ -158,7 +288,7 @@ func (uc *UsersController) GetUsers(c *gin.Context) {
 		}
 
 		/* Return the specific user */
-		c.JSON(http.StatusOK, gin.H{"user": user})
+		c.JSON(http.StatusOK, gin.H{"user": user.AdminResponse()})
 		return
 	}
 
@@ -170,5 +300,131 @@ func (uc *UsersController) GetUsers(c *gin.Context) {
 	}
 
 	/* Return all users */
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	response := make([]models.AdminUserResponse, len(users))
+	for i := range users {
+		response[i] = users[i].AdminResponse()
+	}
+	c.JSON(http.StatusOK, gin.H{"users": response})
+}
+
+// GetBillingProfile returns the billing address the logged-in user pays with.
+// A user who has not saved one gets an empty profile with the default country.
+//
+// Responses:
+//   - 200 OK: {"data": <billing profile>}.
+//   - 401 Unauthorized: If the user ID in the token is invalid.
+func (uc *UsersController) GetBillingProfile(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	profile := models.BillingProfile{UserID: userID, CountryCode: "KE"}
+	uc.DB.Where("user_id = ?", userID).First(&profile)
+
+	c.JSON(http.StatusOK, gin.H{"data": profile})
+}
+
+// UpdateBillingProfile creates or replaces the logged-in user's billing address.
+// The address is sent to Pesapal with every payment the user makes.
+//
+// Request Body:
+//   - line_1, line_2, city, state, postal_code (optional): Address fields.
+//   - country_code (optional): Two-letter ISO country code. Defaults to KE.
+//
+// Responses:
+//   - 200 OK: The saved billing profile.
+//   - 400 Bad Request: Invalid input.
+//   - 401 Unauthorized: If the user ID in the token is invalid.
+//   - 500 Internal Server Error: If the profile cannot be saved.
+func (uc *UsersController) UpdateBillingProfile(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	var input models.BillingProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	var profile models.BillingProfile
+	uc.DB.Where("user_id = ?", userID).First(&profile)
+
+	profile.UserID = userID
+	profile.Line1 = input.Line1
+	profile.Line2 = input.Line2
+	profile.City = input.City
+	profile.State = input.State
+	profile.PostalCode = input.PostalCode
+	profile.CountryCode = strings.ToUpper(input.CountryCode)
+	if profile.CountryCode == "" {
+		profile.CountryCode = "KE"
+	}
+
+	if err := uc.DB.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save billing profile", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Billing profile saved", "data": profile})
+}
+
+// UpdateUserRole changes another user's role. Admin only; this is the only way
+// to grant the admin role, and the tutor role once a tutor's application has
+// been reviewed. Access tokens carry the role, so a change revokes all of the
+// user's sessions and the new role applies from their next login; a demoted
+// admin loses access at once rather than when their token is refreshed.
+//
+// Request Body:
+//   - role (required): One of student, parent, teacher, tutor, school or admin.
+//
+// Responses:
+//   - 200 OK: The role was updated.
+//   - 400 Bad Request: Invalid user ID or role, or an admin demoting themselves.
+//   - 404 Not Found: No user with the given ID.
+//   - 500 Internal Server Error: If the role cannot be saved.
+func (uc *UsersController) UpdateUserRole(c *gin.Context) {
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !models.IsValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	parsedUserID, ok := utils.ParseUserIDFromString(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	/* Stop admins from locking themselves out */
+	if parsedUserID.String() == c.GetString("user_id") && input.Role != models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your own admin role"})
+		return
+	}
+
+	var user models.User
+	if err := uc.DB.First(&user, "id = ?", parsedUserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Role != input.Role {
+		err := uc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("role", input.Role).Error; err != nil {
+				return err
+			}
+			return revokeUserSessions(tx, user.ID, nil)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role", "details": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "user_id": user.ID, "role": input.Role})
 }
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpdateUserRoleRevokesSessions(t *testing.T) {
+	db := testDB(t)
+	gin.SetMode(gin.TestMode)
+
+	admin := createTestUser(t, db)
+	user := createTestUser(t, db)
+	db.Model(user).Update("role", models.RoleAdmin)
+	session := createTestSession(t, db, user.ID)
+
+	uc := &UsersController{DB: db}
+	router := gin.New()
+	router.PATCH("/users/:id/role", func(c *gin.Context) { c.Set("user_id", c.GetHeader("X-User-ID")) }, uc.UpdateUserRole)
+
+	code, body := serve(t, router, http.MethodPatch, "/users/"+user.ID.String()+"/role", admin.ID.String(), `{"role":"student"}`)
+	if code != http.StatusOK {
+		t.Fatalf("UpdateUserRole answered %d: %v", code, body)
+	}
+
+	assertSessionRevoked(t, db, session)
+}
+
+func TestEmailChangeWaitsForConfirmation(t *testing.T) {
+	db := testDB(t)
+	gin.SetMode(gin.TestMode)
+	previousSecret := utils.JWT_SECRET
+	utils.JWT_SECRET = "test-secret"
+	t.Cleanup(func() { utils.JWT_SECRET = previousSecret })
+
+	user := createTestUser(t, db)
+	session := createTestSession(t, db, user.ID)
+	newEmail := "new-" + user.Email
+
+	uc := &UsersController{DB: db}
+	ac := &AuthController{DB: db}
+	router := gin.New()
+	router.PUT("/profile", func(c *gin.Context) { c.Set("user_id", c.GetHeader("X-User-ID")) }, uc.UpdateProfile)
+	router.POST("/verify-email", ac.VerifyEmail)
+
+	code, body := serve(t, router, http.MethodPut, "/profile", user.ID.String(), `{"email":"`+newEmail+`"}`)
+	if code != http.StatusOK {
+		t.Fatalf("UpdateProfile answered %d: %v", code, body)
+	}
+
+	var stored models.User
+	db.First(&stored, "id = ?", user.ID)
+	if stored.Email != user.Email || !stored.IsActive || stored.EmailVerifiedAt == nil || stored.PendingEmail != newEmail {
+		t.Fatalf("after the change: email %q, active %v, pending %q; want the verified address kept and %q pending",
+			stored.Email, stored.IsActive, stored.PendingEmail, newEmail)
+	}
+	db.First(session, "id = ?", session.ID)
+	if !session.IsActive(time.Now()) {
+		t.Error("requesting an email change logged the user out")
+	}
+
+	/* A link for the current address does not confirm the pending one */
+	oldToken, _ := utils.GenerateEmailVerificationToken(user.ID, user.Email)
+	serve(t, router, http.MethodPost, "/verify-email", "", `{"token":"`+oldToken+`"}`)
+	db.First(&stored, "id = ?", user.ID)
+	if stored.Email != user.Email {
+		t.Fatalf("email changed to %q without the new address's link", stored.Email)
+	}
+
+	token, _ := utils.GenerateEmailVerificationToken(user.ID, newEmail)
+	if code, body := serve(t, router, http.MethodPost, "/verify-email", "", `{"token":"`+token+`"}`); code != http.StatusOK {
+		t.Fatalf("VerifyEmail answered %d: %v", code, body)
+	}
+	db.First(&stored, "id = ?", user.ID)
+	if stored.Email != newEmail || stored.PendingEmail != "" || !stored.IsActive {
+		t.Errorf("after confirming: email %q, pending %q, active %v", stored.Email, stored.PendingEmail, stored.IsActive)
+	}
+}
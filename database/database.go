@@ -10,8 +10,22 @@ import (
 
 	"github.com/bot-on-tapwater/cbcexams-backend/config"
 	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"gorm.io/gorm"
 )
 
+/*
+Models whose tables AutoMigrate creates and updates. web_crawler_resources is
+not among them: it is loaded from the crawler's database and its extra columns
+are added with raw SQL, so relations to it are tagged "-:migration" to keep
+AutoMigrate from pulling it in.
+*/
+var autoMigratedModels = []interface{}{
+	&models.User{}, &models.TutorApplication{}, &models.TutorRequest{}, &models.SchoolJobListing{}, &models.TeacherJobProfile{},
+	&models.WebDevRequest{}, &models.Feedback{}, &models.Bookmark{}, &models.Order{}, &models.PaymentTransaction{},
+	&models.Subscription{}, &models.Refund{}, &models.BillingProfile{}, &models.Receipt{}, &models.Session{},
+	&models.UserIdentity{}, &models.PhoneOTP{}, &models.RecoveryCode{}, &models.SearchSynonym{}, // Add more models here
+}
+
 // InitializeDatabase connects to the database and runs migrations.
 // InitializeDatabase sets up the database connection and performs
 // the necessary migrations for the application. It ensures that
@@ -22,12 +36,98 @@ func InitializeDatabase() {
 	/* Initialise database connection */
 	db := config.DB
 
+	/* Accounts created before email verification existed count as verified */
+	backfillVerified := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "email_verified_at")
+
+	if err := prepareUserIdentifiers(db); err != nil {
+		log.Fatalf("Failed to prepare user identifiers: %v", err)
+	}
+
 	/* Run migrations */
 	fmt.Println("Running database migrations...")
-	err := db.AutoMigrate(&models.User{}, &models.TutorApplication{}, &models.TutorRequest{}, &models.SchoolJobListing{}, &models.TeacherJobProfile{}, &models.WebDevRequest{}, &models.Feedback{}, &models.Bookmark{}) // Add more models here
+	err := db.AutoMigrate(autoMigratedModels...)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	if backfillVerified {
+		if err := db.Exec("UPDATE users SET is_active = true, email_verified_at = NOW() WHERE email_verified_at IS NULL").Error; err != nil {
+			log.Fatalf("Failed to mark existing users as verified: %v", err)
+		}
+	}
+
+	if err := migrateAdminFlagToRole(db); err != nil {
+		log.Fatalf("Failed to migrate admin flags to roles: %v", err)
+	}
+
+	if err := migrateResourceDeletion(db); err != nil {
+		log.Fatalf("Failed to set up resource deletion: %v", err)
+	}
+
+	if err := migrateResourceSearch(db); err != nil {
+		log.Fatalf("Failed to set up resource search: %v", err)
+	}
+
+	if err := migrateResourceFacets(db); err != nil {
+		log.Fatalf("Failed to set up resource filters: %v", err)
+	}
+
+	if err := migrateFuzzySearch(db); err != nil {
+		log.Fatalf("Failed to set up fuzzy search: %v", err)
+	}
+
+	if err := migrateSearchVocabulary(db); err != nil {
+		log.Fatalf("Failed to set up search suggestions: %v", err)
+	}
+
+	if err := seedSearchSynonyms(db); err != nil {
+		log.Fatalf("Failed to seed search synonyms: %v", err)
+	}
+
 	fmt.Println("Database migrated successfully!")
 }
+
+// prepareUserIdentifiers gets the users table ready for phone-only accounts.
+// Email used to be unique through a table constraint, which also forbids
+// several users without an email address; it is replaced by a partial unique
+// index created by AutoMigrate. Phone numbers become unique the same way, so
+// duplicates saved before then are cleared from all but the oldest account.
+func prepareUserIdentifiers(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.User{}) {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, constraint := range []string{"uni_users_email", "users_email_key"} {
+			if err := tx.Exec("ALTER TABLE users DROP CONSTRAINT IF EXISTS " + constraint).Error; err != nil {
+				return err
+			}
+		}
+
+		if !tx.Migrator().HasColumn(&models.User{}, "phone_number") || tx.Migrator().HasIndex(&models.User{}, "idx_users_phone_number") {
+			return nil
+		}
+		return tx.Exec(`UPDATE users SET phone_number = '' WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY phone_number ORDER BY last_login, id) AS rn
+				FROM users WHERE phone_number <> ''
+			) duplicates WHERE rn > 1
+		)`).Error
+	})
+}
+
+// migrateAdminFlagToRole replaces the old users.is_admin flag with the admin
+// role. Users flagged as admins keep their access; the flag column is dropped
+// afterwards so this only does work once.
+func migrateAdminFlagToRole(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.User{}, "is_admin") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE users SET role = ? WHERE is_admin", models.RoleAdmin).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&models.User{}, "is_admin")
+	})
+}
@@ -0,0 +1,442 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/* Bulk import formats */
+const (
+	ImportFormatCSV   = "csv"   /* A header row naming the columns, then one resource per row */
+	ImportFormatJSONL = "jsonl" /* One JSON object per line */
+)
+
+/* Import limits */
+const (
+	importBatchSize   = 500              /* Rows looked up or written per statement */
+	importMaxLineSize = 16 * 1024 * 1024 /* Longest JSONL line, extracted text included */
+)
+
+/*
+Columns an import may set from excluded (the new row) on conflict; a row only
+sets those its file provides. Classification columns are handled separately.
+*/
+var importUpdateColumns = []string{
+	"parent_url", "google_drive_download_link", "name", "relative_path", "parent_directory",
+	"google_cloud_storage_link", "is_extracted", "extracted_content",
+}
+
+/* Columns the classifier sets, kept as they are on resources an admin recategorized */
+var importClassificationColumns = []string{
+	"level", "subject", "resource_type", "term", "year", "categories", "classifier_version",
+}
+
+/* ErrInvalidImport wraps errors about an import file as a whole, such as an unknown format or CSV column */
+var ErrInvalidImport = errors.New("invalid import")
+
+/* errDryRun rolls back a dry-run import once every row has been written */
+var errDryRun = errors.New("dry run")
+
+/* ImportRowError is a row left out of an import and why */
+type ImportRowError struct {
+	Line               int    `json:"line"`
+	DjangoRelativePath string `json:"django_relative_path,omitempty"`
+	Error              string `json:"error"`
+}
+
+/* ImportReport summarises a bulk import; in a dry run it says what the import would do */
+type ImportReport struct {
+	Format   string           `json:"format"`
+	DryRun   bool             `json:"dry_run"`
+	Rows     int              `json:"rows"` /* Rows read, not counting a CSV header */
+	Created  int              `json:"created"`
+	Updated  int              `json:"updated"`
+	Restored int              `json:"restored"` /* Updated resources that had been deleted */
+	Invalid  int              `json:"invalid"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+/* importRow is a parsed row, the line it started on and the columns (CSV) or keys (JSONL) it gave */
+type importRow struct {
+	line    int
+	input   models.ResourceInput
+	columns map[string]bool
+}
+
+/* existingResource is what an import needs to know about a resource already in the table */
+type existingResource struct {
+	DjangoRelativePath      string
+	GoogleDriveDownloadLink string
+	ParentDirectory         string
+	Deleted                 bool
+}
+
+// migrateResourceDeletion adds the deleted_at column that soft-deletes
+// resources. Like the search and filter columns it is added with raw SQL
+// because web_crawler_resources is not auto-migrated.
+func migrateResourceDeletion(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.WebCrawlerResource{}) {
+		return nil
+	}
+
+	if err := db.Exec(`ALTER TABLE web_crawler_resources ADD COLUMN IF NOT EXISTS deleted_at timestamptz`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_deleted_at ON web_crawler_resources (deleted_at)`).Error
+}
+
+// ImportFormatFromName guesses an import's format from a file name.
+//
+// Returns:
+//   - string: ImportFormatCSV, ImportFormatJSONL or "" if the extension is unknown.
+func ImportFormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ImportFormatCSV
+	case ".jsonl", ".ndjson":
+		return ImportFormatJSONL
+	}
+	return ""
+}
+
+// ImportResources creates or updates resources from a CSV or JSONL file.
+// Columns (CSV) and keys (JSONL) are the JSON names of models.ResourceInput.
+// Resources are matched on django_relative_path: new paths are created,
+// existing ones are updated, and deleted ones are restored. An update only
+// changes the columns the file has, so a file of names and links leaves
+// extracted text alone. Labels an admin set by hand are kept.
+//
+// Rows that fail validation, repeat an earlier row's path or Drive link, or
+// use a Drive link that belongs to another resource are left out and listed
+// in the report; the rest are written in one transaction. A dry run writes
+// them too, so database errors surface, then rolls back.
+//
+// Parameters:
+//   - db: The database connection.
+//   - r: The file contents.
+//   - format: ImportFormatCSV or ImportFormatJSONL.
+//   - dryRun: Report what would change without changing anything.
+//
+// Returns:
+//   - *ImportReport: What was (or would be) created, updated and rejected.
+//   - error: An error wrapping ErrInvalidImport if the file cannot be read as
+//     a whole, or the database error if a lookup or write failed.
+func ImportResources(db *gorm.DB, r io.Reader, format string, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{Format: format, DryRun: dryRun, Errors: []ImportRowError{}}
+
+	var rows []importRow
+	var err error
+	switch format {
+	case ImportFormatCSV:
+		rows, err = readCSVImport(r, report)
+	case ImportFormatJSONL:
+		rows, err = readJSONLImport(r, report)
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q; use %s or %s", ErrInvalidImport, format, ImportFormatCSV, ImportFormatJSONL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, existing, err := checkImportRows(db, rows, report)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Resources are classified from their parent directory, so one the file leaves out keeps its stored value */
+	for i := range rows {
+		if current, ok := existing[rows[i].input.DjangoRelativePath]; ok && !rows[i].columns["parent_directory"] {
+			rows[i].input.ParentDirectory = current.ParentDirectory
+		}
+	}
+
+	/* Rows that set the same columns are written together */
+	var groups []string
+	grouped := make(map[string][]importRow)
+	for _, row := range rows {
+		key := strings.Join(importRowUpdateColumns(row), ",")
+		if _, ok := grouped[key]; !ok {
+			groups = append(groups, key)
+		}
+		grouped[key] = append(grouped[key], row)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range groups {
+			group := grouped[key]
+			onConflict := importOnConflict(importRowUpdateColumns(group[0]))
+			for start := 0; start < len(group); start += importBatchSize {
+				end := min(start+importBatchSize, len(group))
+				batch := make([]models.WebCrawlerResource, 0, end-start)
+				for _, row := range group[start:end] {
+					var resource models.WebCrawlerResource
+					row.input.ApplyTo(&resource)
+					batch = append(batch, resource)
+				}
+				if err := tx.Clauses(onConflict).Create(&batch).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		switch current, ok := existing[row.input.DjangoRelativePath]; {
+		case !ok:
+			report.Created++
+		case current.Deleted:
+			report.Updated++
+			report.Restored++
+		default:
+			report.Updated++
+		}
+	}
+
+	if !dryRun && len(rows) > 0 {
+		if err := RefreshSearchVocabulary(db); err != nil {
+			log.Printf("Failed to refresh search suggestions after import: %v", err)
+		}
+	}
+	return report, nil
+}
+
+// importRowUpdateColumns returns the importUpdateColumns a row sets on an
+// existing resource: those in its file, plus is_extracted when the file has
+// extracted_content (it is derived from the text unless given) and
+// parent_directory, which ImportResources fills in from the stored resource.
+func importRowUpdateColumns(row importRow) []string {
+	columns := make([]string, 0, len(importUpdateColumns))
+	for _, column := range importUpdateColumns {
+		if row.columns[column] || column == "parent_directory" ||
+			(column == "is_extracted" && row.columns["extracted_content"]) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+/* importOnConflict updates the given columns of a resource with the same django_relative_path, restoring it if deleted */
+func importOnConflict(columns []string) clause.OnConflict {
+	assignments := clause.AssignmentColumns(columns)
+	for _, column := range importClassificationColumns {
+		assignments = append(assignments, clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value: gorm.Expr(fmt.Sprintf(
+				"CASE WHEN web_crawler_resources.manually_classified THEN web_crawler_resources.%[1]s ELSE excluded.%[1]s END", column)),
+		})
+	}
+	assignments = append(assignments, clause.Assignment{Column: clause.Column{Name: "deleted_at"}, Value: gorm.Expr("NULL")})
+
+	return clause.OnConflict{
+		Columns:   []clause.Column{{Name: "django_relative_path"}},
+		DoUpdates: assignments,
+	}
+}
+
+/* rejectImportRow records a row left out of an import */
+func rejectImportRow(report *ImportReport, line int, path string, err error) {
+	report.Invalid++
+	report.Errors = append(report.Errors, ImportRowError{Line: line, DjangoRelativePath: path, Error: err.Error()})
+}
+
+/* importFieldSetters set a models.ResourceInput field from a CSV cell, keyed by column name */
+var importFieldSetters = map[string]func(*models.ResourceInput, string) error{
+	"name":                       func(in *models.ResourceInput, v string) error { in.Name = v; return nil },
+	"parent_url":                 func(in *models.ResourceInput, v string) error { in.ParentURL = v; return nil },
+	"parent_directory":           func(in *models.ResourceInput, v string) error { in.ParentDirectory = v; return nil },
+	"relative_path":              func(in *models.ResourceInput, v string) error { in.RelativePath = v; return nil },
+	"django_relative_path":       func(in *models.ResourceInput, v string) error { in.DjangoRelativePath = v; return nil },
+	"google_drive_download_link": func(in *models.ResourceInput, v string) error { in.GoogleDriveDownloadLink = v; return nil },
+	"google_cloud_storage_link":  func(in *models.ResourceInput, v string) error { in.GoogleCloudStorageLink = v; return nil },
+	"extracted_content":          func(in *models.ResourceInput, v string) error { in.ExtractedContent = v; return nil },
+	"is_extracted": func(in *models.ResourceInput, v string) error {
+		if strings.TrimSpace(v) == "" {
+			return nil
+		}
+		extracted, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return errors.New("is_extracted must be true or false")
+		}
+		in.IsExtracted = &extracted
+		return nil
+	},
+}
+
+/* readCSVImport parses a CSV import; rows with the wrong number of cells or bad values are rejected */
+func readCSVImport(r io.Reader, report *ImportReport) ([]importRow, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading CSV header: %v", ErrInvalidImport, err)
+	}
+	setters := make([]func(*models.ResourceInput, string) error, len(header))
+	columns := make(map[string]bool, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		setter, ok := importFieldSetters[column]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown CSV column %q", ErrInvalidImport, column)
+		}
+		setters[i] = setter
+		columns[column] = true
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			if !errors.Is(err, csv.ErrFieldCount) {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+			}
+			report.Rows++
+			rejectImportRow(report, line, "", err)
+			continue
+		}
+		report.Rows++
+
+		row := importRow{line: line, columns: columns}
+		var rowErr error
+		for i, value := range record {
+			if err := setters[i](&row.input, value); err != nil {
+				rowErr = err
+				break
+			}
+		}
+		if rowErr != nil {
+			rejectImportRow(report, line, strings.TrimSpace(row.input.DjangoRelativePath), rowErr)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+/* readJSONLImport parses a JSONL import; blank lines are skipped and lines that are not a resource object are rejected */
+func readJSONLImport(r io.Reader, report *ImportReport) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), importMaxLineSize)
+
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		report.Rows++
+
+		row := importRow{line: line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.input); err != nil {
+			rejectImportRow(report, line, "", err)
+			continue
+		}
+
+		/* A key set to null counts as given, the same as an empty CSV cell */
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(text, &keys); err != nil {
+			rejectImportRow(report, line, "", err)
+			continue
+		}
+		row.columns = make(map[string]bool, len(keys))
+		for key := range keys {
+			row.columns[strings.ToLower(key)] = true
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: reading JSONL: %v", ErrInvalidImport, err)
+	}
+	return rows, nil
+}
+
+// checkImportRows validates parsed rows and rejects those that cannot be
+// written: invalid input, a django_relative_path or Drive link repeated from
+// an earlier row, or a Drive link that belongs to another resource.
+//
+// Returns:
+//   - []importRow: The rows to write.
+//   - map[string]existingResource: Resources already stored under the rows' paths, deleted ones included.
+//   - error: An error if a lookup failed.
+func checkImportRows(db *gorm.DB, rows []importRow, report *ImportReport) ([]importRow, map[string]existingResource, error) {
+	seenPaths := make(map[string]int)
+	seenLinks := make(map[string]int)
+	valid := rows[:0]
+	for _, row := range rows {
+		if err := row.input.Validate(); err != nil {
+			rejectImportRow(report, row.line, row.input.DjangoRelativePath, err)
+			continue
+		}
+		if first, ok := seenPaths[row.input.DjangoRelativePath]; ok {
+			rejectImportRow(report, row.line, row.input.DjangoRelativePath, fmt.Errorf("django_relative_path repeats line %d", first))
+			continue
+		}
+		if first, ok := seenLinks[row.input.GoogleDriveDownloadLink]; ok {
+			rejectImportRow(report, row.line, row.input.DjangoRelativePath, fmt.Errorf("google_drive_download_link repeats line %d", first))
+			continue
+		}
+		seenPaths[row.input.DjangoRelativePath] = row.line
+		seenLinks[row.input.GoogleDriveDownloadLink] = row.line
+		valid = append(valid, row)
+	}
+
+	existing := make(map[string]existingResource)
+	linkOwners := make(map[string]string)
+	for start := 0; start < len(valid); start += importBatchSize {
+		end := min(start+importBatchSize, len(valid))
+		paths := make([]string, 0, end-start)
+		links := make([]string, 0, end-start)
+		for _, row := range valid[start:end] {
+			paths = append(paths, row.input.DjangoRelativePath)
+			links = append(links, row.input.GoogleDriveDownloadLink)
+		}
+
+		var found []existingResource
+		if err := db.Unscoped().Model(&models.WebCrawlerResource{}).
+			Select("django_relative_path, google_drive_download_link, parent_directory, deleted_at IS NOT NULL AS deleted").
+			Where("django_relative_path IN ? OR google_drive_download_link IN ?", paths, links).
+			Scan(&found).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, resource := range found {
+			existing[resource.DjangoRelativePath] = resource
+			linkOwners[resource.GoogleDriveDownloadLink] = resource.DjangoRelativePath
+		}
+	}
+
+	writable := valid[:0]
+	for _, row := range valid {
+		if owner, ok := linkOwners[row.input.GoogleDriveDownloadLink]; ok && owner != row.input.DjangoRelativePath {
+			rejectImportRow(report, row.line, row.input.DjangoRelativePath, fmt.Errorf("google_drive_download_link belongs to %s", owner))
+			continue
+		}
+		writable = append(writable, row)
+	}
+	return writable, existing, nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	config.InitTimezone()
+	os.Exit(m.Run())
+}
+
+/* dryRunDB returns a Postgres connection that builds queries without running them */
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("open dry-run database: %v", err)
+	}
+	return db
+}
+
+func TestAutoMigrateSkipsResources(t *testing.T) {
+	reorderer, ok := dryRunDB(t).Migrator().(interface {
+		ReorderModels(values []interface{}, autoAdd bool) []interface{}
+	})
+	if !ok {
+		t.Fatal("the postgres migrator cannot reorder models")
+	}
+
+	for _, model := range reorderer.ReorderModels(autoMigratedModels, true) {
+		if _, ok := model.(*models.WebCrawlerResource); ok {
+			t.Fatal("AutoMigrate would migrate web_crawler_resources")
+		}
+	}
+}
+
+func TestImportUpdatesOnlyGivenColumns(t *testing.T) {
+	csvRows, err := readCSVImport(strings.NewReader(
+		"name,django_relative_path,google_drive_download_link\n"+
+			"Grade 7 Maths.pdf,/grade-7/maths.pdf,https://drive.google.com/1\n"), &ImportReport{})
+	if err != nil || len(csvRows) != 1 {
+		t.Fatalf("readCSVImport = %d rows, %v", len(csvRows), err)
+	}
+	jsonlRows, err := readJSONLImport(strings.NewReader(
+		`{"Name":"Grade 7 Maths.pdf","django_relative_path":"/grade-7/maths.pdf","google_drive_download_link":"https://drive.google.com/1","extracted_content":"Fractions"}`+"\n"), &ImportReport{})
+	if err != nil || len(jsonlRows) != 1 {
+		t.Fatalf("readJSONLImport = %d rows, %v", len(jsonlRows), err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		row  importRow
+		want []string
+	}{
+		{"csv", csvRows[0], []string{"google_drive_download_link", "name", "parent_directory"}},
+		{"jsonl", jsonlRows[0], []string{"google_drive_download_link", "name", "parent_directory", "is_extracted", "extracted_content"}},
+	} {
+		if got := importRowUpdateColumns(tc.row); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: update columns = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	var resource models.WebCrawlerResource
+	csvRows[0].input.ApplyTo(&resource)
+	query := dryRunDB(t).Clauses(importOnConflict(importRowUpdateColumns(csvRows[0]))).Create(&resource)
+	if query.Error != nil {
+		t.Fatalf("building the upsert: %v", query.Error)
+	}
+
+	update := query.Statement.SQL.String()
+	update = update[strings.Index(update, "DO UPDATE SET"):]
+	for _, column := range []string{"extracted_content", "is_extracted", "parent_url", "relative_path", "google_cloud_storage_link"} {
+		if strings.Contains(update, `"`+column+`"=`) {
+			t.Errorf("an import without %s overwrites it: %s", column, update)
+		}
+	}
+	for _, column := range []string{"name", "level", "deleted_at"} {
+		if !strings.Contains(update, `"`+column+`"=`) {
+			t.Errorf("the upsert does not set %s: %s", column, update)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// migrateResourceSearch sets up full-text search over web_crawler_resources.
+// The table is loaded from the crawler's database rather than AutoMigrate,
+// so the search column, the trigger that keeps it current and its GIN index
+// are created here with raw SQL. Every statement is idempotent; existing rows
+// are indexed the first time this runs.
+//
+// The search vector weights the resource name highest (A), then its parent
+// directory (B), then the extracted text (C). Separators common in file and
+// folder names are turned into spaces so "Grade-7_Maths.pdf" matches "grade 7".
+func migrateResourceSearch(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.WebCrawlerResource{}) {
+		return nil
+	}
+
+	backfill := !db.Migrator().HasColumn(&models.WebCrawlerResource{}, "search_vector")
+
+	statements := []string{
+		`ALTER TABLE web_crawler_resources ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+
+		/* Extracted text is capped so very long documents stay under the tsvector size limit */
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION web_crawler_resource_search_vector(name text, parent_directory text, extracted_content text)
+		RETURNS tsvector AS $$
+			SELECT setweight(to_tsvector('%[1]s', translate(coalesce(name, ''), '-_/.', '    ')), 'A') ||
+				setweight(to_tsvector('%[1]s', translate(coalesce(parent_directory, ''), '-_/.', '    ')), 'B') ||
+				setweight(to_tsvector('%[1]s', left(coalesce(extracted_content, ''), %[2]d)), 'C')
+		$$ LANGUAGE sql IMMUTABLE`, models.ResourceSearchConfig, models.ResourceSearchContentLimit),
+
+		`CREATE OR REPLACE FUNCTION web_crawler_resources_search_vector_update() RETURNS trigger AS $$
+		BEGIN
+			NEW.search_vector := web_crawler_resource_search_vector(NEW.name, NEW.parent_directory, NEW.extracted_content);
+			RETURN NEW;
+		END
+		$$ LANGUAGE plpgsql`,
+
+		`DROP TRIGGER IF EXISTS web_crawler_resources_search_vector_trigger ON web_crawler_resources`,
+
+		`CREATE TRIGGER web_crawler_resources_search_vector_trigger
+		BEFORE INSERT OR UPDATE OF name, parent_directory, extracted_content ON web_crawler_resources
+		FOR EACH ROW EXECUTE FUNCTION web_crawler_resources_search_vector_update()`,
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		if backfill {
+			fmt.Println("Indexing resources for full-text search...")
+			if err := tx.Exec(`UPDATE web_crawler_resources
+				SET search_vector = web_crawler_resource_search_vector(name, parent_directory, extracted_content)`).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec(`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_search_vector
+			ON web_crawler_resources USING GIN (search_vector)`).Error
+	})
+}
+
+/* Rows classified per transaction when backfilling resource facets */
+const resourceClassifyBatchSize = 1000
+
+// migrateResourceFacets adds the level, subject, resource_type, term and year
+// columns that resources are filtered and faceted by, with their indexes.
+// Like the search column they are added with raw SQL because the table is not
+// auto-migrated. New and edited resources are classified by the model's
+// BeforeSave hook; rows classified by an older utils.ClassifierVersion
+// (including every existing row, the first time) are classified again here,
+// except those an admin recategorized by hand.
+func migrateResourceFacets(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.WebCrawlerResource{}) {
+		return nil
+	}
+
+	statements := []string{
+		`ALTER TABLE web_crawler_resources
+			ADD COLUMN IF NOT EXISTS level varchar(20) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS subject varchar(60) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS resource_type varchar(40) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS term smallint NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS year smallint NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS classifier_version smallint NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS manually_classified boolean NOT NULL DEFAULT false`,
+		`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_level ON web_crawler_resources (level)`,
+		`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_subject ON web_crawler_resources (subject)`,
+		`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_resource_type ON web_crawler_resources (resource_type)`,
+		`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_term ON web_crawler_resources (term)`,
+		`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_year ON web_crawler_resources (year)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return classifyResources(db)
+}
+
+/* classifyResources classifies every resource not yet classified by the current utils.ClassifierVersion */
+func classifyResources(db *gorm.DB) error {
+	var resources []models.WebCrawlerResource
+	classified := 0
+
+	result := db.Select("id", "name", "parent_directory").
+		Where("classifier_version < ? AND NOT manually_classified", utils.ClassifierVersion).
+		FindInBatches(&resources, resourceClassifyBatchSize, func(_ *gorm.DB, _ int) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				for i := range resources {
+					resource := &resources[i]
+					resource.Classify()
+					if err := tx.Model(resource).UpdateColumns(map[string]interface{}{
+						"level":              resource.Level,
+						"subject":            resource.Subject,
+						"resource_type":      resource.ResourceType,
+						"term":               resource.Term,
+						"year":               resource.Year,
+						"categories":         pq.StringArray(resource.Categories),
+						"classifier_version": resource.ClassifierVersion,
+					}).Error; err != nil {
+						return err
+					}
+				}
+				classified += len(resources)
+				return nil
+			})
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if classified > 0 {
+		fmt.Printf("Classified %d resources for filtering\n", classified)
+	}
+	return nil
+}
+
+// migrateFuzzySearch sets up the pg_trgm extension and trigram indexes on the
+// lower-cased resource names and directories, which serve the <% operator
+// used by fuzzy search.
+func migrateFuzzySearch(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.WebCrawlerResource{}) {
+		return nil
+	}
+
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`).Error; err != nil {
+		return err
+	}
+	for _, column := range []string{"name", "parent_directory"} {
+		if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_web_crawler_resources_` + column + `_trgm
+			ON web_crawler_resources USING GIN (lower(` + column + `) gin_trgm_ops)`).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSearchVocabulary sets up the pg_trgm extension and the
+// resource_search_words materialized view: every word used in resource names
+// and directories with the number of resources it appears in. Misspelt search
+// words are compared against it by trigram similarity to suggest corrections.
+// The view is refreshed on every start so it follows imported resources, and
+// rebuilt if it predates deleted resources being left out.
+func migrateSearchVocabulary(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.WebCrawlerResource{}) {
+		return nil
+	}
+
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`).Error; err != nil {
+		return err
+	}
+
+	var definition string
+	if err := db.Raw(`SELECT definition FROM pg_matviews WHERE matviewname = 'resource_search_words'`).Scan(&definition).Error; err != nil {
+		return err
+	}
+	if strings.Contains(definition, "deleted_at") {
+		return RefreshSearchVocabulary(db)
+	}
+
+	/* Words are not stemmed so suggestions are real words */
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`DROP MATERIALIZED VIEW IF EXISTS resource_search_words`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`CREATE MATERIALIZED VIEW resource_search_words AS
+			SELECT word, ndoc FROM ts_stat($$
+				SELECT to_tsvector('simple', translate(coalesce(name, '') || ' ' || coalesce(parent_directory, ''), '-_/.', '    '))
+				FROM web_crawler_resources
+				WHERE deleted_at IS NULL
+			$$)
+			WHERE length(word) > 2 AND word !~ '^[0-9]+$'`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`CREATE UNIQUE INDEX idx_resource_search_words_word ON resource_search_words (word)`).Error
+	})
+}
+
+// RefreshSearchVocabulary rebuilds the words used for "did you mean"
+// suggestions from the current resources.
+func RefreshSearchVocabulary(db *gorm.DB) error {
+	return db.Exec(`REFRESH MATERIALIZED VIEW resource_search_words`).Error
+}
+
+// seedSearchSynonyms adds models.DefaultSearchSynonyms to the search_synonyms
+// table. Terms that already exist are left alone, so edited expansions are
+// kept; a deleted default comes back on the next start.
+func seedSearchSynonyms(db *gorm.DB) error {
+	synonyms := make([]models.SearchSynonym, 0, len(models.DefaultSearchSynonyms))
+	for term, expansion := range models.DefaultSearchSynonyms {
+		synonyms = append(synonyms, models.SearchSynonym{Term: term, Expansion: expansion})
+	}
+	return db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "term"}}, DoNothing: true}).Create(&synonyms).Error
+}
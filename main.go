@@ -15,15 +15,10 @@ import (
 	"time"
 
 	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
-	/*
-	   Comment out "database" import after running first migration
-	   There is an issue with the resources table
-	   After importing data into the table trying
-	   to run migrations will cause errors
-	*/
 	"github.com/bot-on-tapwater/cbcexams-backend/database"
 	"github.com/bot-on-tapwater/cbcexams-backend/routes"
 	//"github.com/ulule/limiter/v3"
@@ -33,9 +28,9 @@ import (
 
 // main is the entry point of the application. It performs the following tasks:
 //  1. Establishes a connection to the database using the ConnectDB function from the config package.
-//  2. (Optional) Handles database migrations. Ensure that migrations are run only once or drop the
-//     "resources" table before re-running migrations to avoid errors. Uncomment the
-//     InitializeDatabase function call if migrations are required.
+//  2. Runs database migrations. The resources table is not auto-migrated, so migrations are safe
+//     to run on every start; resources are loaded through the admin import endpoint or
+//     cmd/import-resources.
 //  3. Sets up the HTTP router by passing the database connection to the SetupRouter function
 //     from the routes package.
 //  4. Starts the application on port 8080, making it ready to handle incoming HTTP requests.
@@ -46,14 +41,12 @@ func main() {
 	/* Initialize the EAT timezone */
 	config.InitTimezone()
 
-	/*
-	   Only run migrations once
-	   Or drop the "resources" table before running
-	   migrations again to avoid errors
-	*/
 	/* Run database migrations */
 	database.InitializeDatabase()
 
+	/* Erase accounts whose deletion grace period has ended */
+	go controllers.RunAccountPurger(db, time.Hour)
+
 	/* Configure Gin */
 	gin.SetMode(gin.ReleaseMode) // Switch to gin.DebugMode in development
 	r := gin.Default()
@@ -138,7 +131,8 @@ func main() {
 	routes.WebDevRoutes(r, db)
 	routes.FeedbackRoutes(r, db)
 	routes.BookmarkRoutes(r, db)
-	routes.PaymentRoutes(r)
+	routes.PaymentRoutes(r, db)
+	routes.SubscriptionRoutes(r, db)
 	routes.ResourceRoutes(r, db)
 
 	/* Print all registered routes */
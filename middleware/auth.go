@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/bot-on-tapwater/cbcexams-backend/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // JWTAuth is a middleware function for Gin that validates JSON Web Tokens (JWT)
@@ -14,8 +19,12 @@ import (
 // the token is present, properly formatted, and valid. If the token is invalid
 // or missing, the middleware aborts the request with a 401 Unauthorized status.
 //
-// The middleware extracts the "user_id" claim from the token and sets it in
-// the Gin context for downstream handlers to use.
+// The token must also belong to a session that has not been revoked or expired,
+// so logging out takes effect immediately rather than when the token expires.
+//
+// The middleware extracts the "user_id", "role" and "sid" claims from the token
+// and sets them in the Gin context ("sid" as "session_id") for downstream
+// handlers to use.
 //
 // Usage:
 // Add this middleware to your Gin router to protect routes that require
@@ -27,7 +36,7 @@ import (
 // router.GET("/protected", protectedHandler)
 //
 // Returns:
-// - HTTP 401 Unauthorized if the "Authorization" header is missing or the token is invalid.
+// - HTTP 401 Unauthorized if the "Authorization" header is missing, the token is invalid or its session has been revoked.
 // - Proceeds to the next handler if the token is valid.
 func JWTAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -37,15 +46,78 @@ func JWTAuth() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := strings.Split(authHeader, "Bearer ")[1]
-		token, err := utils.ValidateJWT(tokenString)
-		if err != nil || !token.Valid {
+		claims, ok := parseBearerToken(authHeader)
+		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		c.Set("user_id", claims["user_id"])
+		setClaims(c, claims)
+		c.Next()
+	}
+}
+
+// OptionalJWTAuth behaves like JWTAuth for requests that carry a valid token,
+// setting "user_id" in the Gin context, but lets anonymous requests and
+// requests with invalid tokens through untouched. Use it on public routes
+// whose response depends on who is asking.
+func OptionalJWTAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, ok := parseBearerToken(c.GetHeader("Authorization")); ok {
+			setClaims(c, claims)
+		}
 		c.Next()
 	}
 }
+
+/* setClaims copies the claims handlers rely on into the Gin context */
+func setClaims(c *gin.Context, claims jwt.MapClaims) {
+	c.Set("user_id", claims["user_id"])
+	c.Set("session_id", claims["sid"])
+	if role, ok := claims["role"].(string); ok {
+		c.Set("role", role)
+	}
+}
+
+/* sessionActive reports whether the session named in the token's "sid" claim is still active */
+func sessionActive(claims jwt.MapClaims) bool {
+	sessionID, err := uuid.Parse(fmt.Sprint(claims["sid"]))
+	if err != nil {
+		return false
+	}
+
+	var session models.Session
+	if err := config.DB.Select("id", "revoked_at", "expires_at").First(&session, "id = ?", sessionID).Error; err != nil {
+		return false
+	}
+	return session.IsActive(time.Now())
+}
+
+/* parseBearerToken validates a "Bearer <token>" header and returns its claims */
+func parseBearerToken(authHeader string) (jwt.MapClaims, bool) {
+	tokenString, found := strings.CutPrefix(authHeader, "Bearer ")
+	if !found || tokenString == "" {
+		return nil, false
+	}
+
+	token, err := utils.ValidateJWT(tokenString)
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, false
+	}
+
+	/* Single-purpose tokens (e.g. email verification) are not login tokens */
+	if _, hasPurpose := claims["purpose"]; hasPurpose {
+		return nil, false
+	}
+
+	/* Tokens issued before sessions existed carry no "sid" and are rejected */
+	if !sessionActive(claims) {
+		return nil, false
+	}
+	return claims, true
+}
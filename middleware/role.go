@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole is a middleware function for Gin that restricts a route to users
+// holding one of the given roles. It must run after JWTAuth, which sets the
+// "role" claim from the token in the Gin context. Tokens issued before roles
+// existed carry no role and are rejected.
+//
+// Example:
+// admin := r.Group("/admin")
+// admin.Use(JWTAuth(), RequireRole(models.RoleAdmin))
+//
+// Returns:
+// - HTTP 403 Forbidden if the user's role is not in roles.
+// - Proceeds to the next handler otherwise.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You do not have permission to access this resource"})
+	}
+}
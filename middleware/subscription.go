@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SubscriptionStatus is a middleware function for Gin that looks up whether the
+// current user has an active premium subscription and stores the answer in the
+// Gin context under "has_active_subscription".
+//
+// It must run after JWTAuth or OptionalJWTAuth. Anonymous requests, requests
+// with an unparseable user ID and database errors are all treated as having no
+// subscription, so the middleware never blocks a request on its own; handlers
+// decide what to hide.
+//
+// Example:
+// router.GET("/resources", middleware.OptionalJWTAuth(), middleware.SubscriptionStatus(db), handler)
+func SubscriptionStatus(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		active := false
+
+		if userID, err := uuid.Parse(c.GetString("user_id")); err == nil {
+			now := time.Now()
+			var count int64
+			err := db.Model(&models.Subscription{}).
+				Where("user_id = ? AND status = ? AND starts_at <= ? AND expires_at > ?",
+					userID, models.SubscriptionStatusActive, now, now).
+				Count(&count).Error
+			active = err == nil && count > 0
+		}
+
+		c.Set("has_active_subscription", active)
+		c.Next()
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/* Default billing address a user pays with */
+type BillingProfile struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"user_id"`
+	Line1       string    `gorm:"size:255" json:"line_1"`
+	Line2       string    `gorm:"size:255" json:"line_2"`
+	City        string    `gorm:"size:100" json:"city"`
+	State       string    `gorm:"size:100" json:"state"`
+	PostalCode  string    `gorm:"size:20" json:"postal_code"`
+	CountryCode string    `gorm:"size:2;not null;default:'KE'" json:"country_code"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new BillingProfile record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (bp *BillingProfile) BeforeCreate(tx *gorm.DB) (err error) {
+	bp.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// BeforeUpdate is a GORM hook that is triggered before updating a BillingProfile record.
+// It updates the UpdatedAt field with the current time in the configured EAT timezone.
+func (bp *BillingProfile) BeforeUpdate(tx *gorm.DB) (err error) {
+	bp.UpdatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+/* Fields a user may change on their billing profile */
+type BillingProfileInput struct {
+	Line1       string `json:"line_1"`
+	Line2       string `json:"line_2"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	PostalCode  string `json:"postal_code"`
+	CountryCode string `json:"country_code" binding:"omitempty,len=2,alpha"`
+}
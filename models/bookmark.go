@@ -16,8 +16,8 @@ type Bookmark struct {
 	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 
 	/* Relationships */
-	User     User               `gorm:"foreignKey:UserID"`
-	Resource WebCrawlerResource `gorm:"foreignKey:ResourceID"`
+	User     User               `gorm:"foreignKey:UserID" json:"-"`
+	Resource WebCrawlerResource `gorm:"foreignKey:ResourceID;-:migration"` /* web_crawler_resources is migrated with raw SQL, not AutoMigrate */
 }
 
 // BeforeCreate is a GORM hook that is triggered before a new Bookmark record is created in the database.
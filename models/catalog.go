@@ -0,0 +1,32 @@
+package models
+
+/*
+Product is something customers can pay for through Pesapal.
+Prices are set here, on the server, and never taken from the client.
+Products that grant premium access name the plan they activate in PlanCode.
+*/
+type Product struct {
+	Code        string  `json:"code"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Currency    string  `json:"currency"`
+	PlanCode    string  `json:"plan_code,omitempty"`
+}
+
+/* Products on sale, keyed by product code */
+var Products = map[string]Product{}
+
+/* Every subscription plan is sold as a product with the same code */
+func init() {
+	for code, plan := range SubscriptionPlans {
+		Products[code] = Product{
+			Code:        code,
+			Name:        plan.Name,
+			Description: "CBCExams premium: " + plan.Name,
+			Price:       plan.Price,
+			Currency:    plan.Currency,
+			PlanCode:    code,
+		}
+	}
+}
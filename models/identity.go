@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/* Identity providers users can sign in with */
+const (
+	IdentityProviderGoogle = "google"
+)
+
+/*
+UserIdentity links a user to an account at an external identity provider.
+A user may have several identities as well as a password.
+*/
+type UserIdentity struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Provider    string    `gorm:"size:20;not null;uniqueIndex:idx_identity_provider_subject" json:"provider"`
+	Subject     string    `gorm:"size:255;not null;uniqueIndex:idx_identity_provider_subject" json:"-"` /* The provider's stable user ID */
+	Email       string    `gorm:"size:255" json:"email"`
+	LastLoginAt time.Time `json:"last_login_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	/* Relationships */
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new UserIdentity record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (ui *UserIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	ui.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
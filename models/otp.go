@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+PhoneOTP is a one-time login code sent by SMS. Only a hash of the code is
+stored; a code stops working once used, once it expires or after too many
+wrong guesses.
+*/
+type PhoneOTP struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	PhoneNumber string     `gorm:"size:20;not null;index" json:"phone_number"`
+	CodeHash    string     `gorm:"size:64;not null" json:"-"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	ConsumedAt  *time.Time `json:"consumed_at"`
+	IPAddress   string     `gorm:"size:45" json:"ip_address"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new PhoneOTP record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (o *PhoneOTP) BeforeCreate(tx *gorm.DB) (err error) {
+	o.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// IsUsable reports whether the code can still be tried at the given time.
+func (o *PhoneOTP) IsUsable(at time.Time, maxAttempts int) bool {
+	return o.ConsumedAt == nil && at.Before(o.ExpiresAt) && o.Attempts < maxAttempts
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/* Order statuses mirrored from Pesapal's payment_status_description */
+const (
+	OrderStatusPending   = "PENDING"
+	OrderStatusCompleted = "COMPLETED"
+	OrderStatusFailed    = "FAILED"
+	OrderStatusReversed  = "REVERSED"
+	OrderStatusCancelled = "CANCELLED"
+)
+
+/*
+orderTransitions lists the statuses an order may move to from each status.
+Pesapal lets a customer retry a failed payment on the same order, and a
+completed payment can later be reversed (chargeback or refund). Orders that
+have not been paid can be cancelled by an admin.
+*/
+var orderTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusCompleted, OrderStatusFailed, OrderStatusCancelled},
+	OrderStatusFailed:    {OrderStatusCompleted, OrderStatusCancelled},
+	OrderStatusCompleted: {OrderStatusReversed},
+	OrderStatusReversed:  {},
+	OrderStatusCancelled: {},
+}
+
+/* Order submitted to Pesapal through SubmitOrderRequest */
+type Order struct {
+	ID                uuid.UUID            `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	MerchantReference string               `gorm:"size:50;uniqueIndex;not null" json:"merchant_reference"`
+	TrackingID        string               `gorm:"size:100;index" json:"order_tracking_id"`
+	UserID            *uuid.UUID           `gorm:"type:uuid;index" json:"user_id"`
+	Amount            float64              `gorm:"type:numeric(12,2);not null" json:"amount"`
+	Currency          string               `gorm:"size:3;not null;default:'KES'" json:"currency"`
+	Description       string               `gorm:"size:100" json:"description"`
+	ProductCode       string               `gorm:"size:50;index" json:"product_code"`
+	PlanCode          string               `gorm:"size:20" json:"plan_code"`
+	AccountNumber     string               `gorm:"size:100;index" json:"account_number"`
+	ParentOrderID     *uuid.UUID           `gorm:"type:uuid;index" json:"parent_order_id"` /* Set on recurring renewals */
+	Email             string               `gorm:"size:255" json:"email"`
+	PhoneNumber       string               `gorm:"size:20" json:"phone_number"`
+	Status            string               `gorm:"size:20;not null;default:'PENDING';index" json:"status"`
+	RedirectURL       string               `gorm:"type:text" json:"redirect_url"`
+	CreatedAt         time.Time            `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time            `gorm:"autoUpdateTime" json:"updated_at"`
+	Transactions      []PaymentTransaction `gorm:"foreignKey:OrderID" json:"transactions,omitempty"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new Order record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (o *Order) BeforeCreate(tx *gorm.DB) (err error) {
+	o.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// BeforeUpdate is a GORM hook that is triggered before updating an Order record.
+// It updates the UpdatedAt field with the current time in the configured EAT timezone.
+func (o *Order) BeforeUpdate(tx *gorm.DB) (err error) {
+	o.UpdatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// CanTransitionTo reports whether the order may move from its current
+// status to the given status according to the order state machine.
+//
+// Parameters:
+//   - status: The status the order should move to.
+//
+// Returns:
+//   - bool: true if the transition is allowed, false otherwise.
+func (o *Order) CanTransitionTo(status string) bool {
+	for _, next := range orderTransitions[o.Status] {
+		if next == status {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+PaymentTransaction is one entry in an order's status history.
+A row is written for every verified status change reported by Pesapal.
+*/
+type PaymentTransaction struct {
+	ID                uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrderID           uuid.UUID `gorm:"type:uuid;not null;index" json:"order_id"`
+	TrackingID        string    `gorm:"size:100;index" json:"order_tracking_id"`
+	NotificationType  string    `gorm:"size:20" json:"notification_type"`
+	Status            string    `gorm:"size:20" json:"status"`
+	StatusDescription string    `gorm:"type:text" json:"status_description"`
+	PaymentMethod     string    `gorm:"size:50" json:"payment_method"`
+	ConfirmationCode  string    `gorm:"size:100" json:"confirmation_code"`
+	Amount            float64   `gorm:"type:numeric(12,2)" json:"amount"`
+	RawPayload        string    `gorm:"type:text" json:"raw_payload"`
+	CreatedAt         time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new PaymentTransaction
+// record is created in the database. It sets the CreatedAt field to the current
+// time in the East Africa Time (EAT) timezone.
+func (pt *PaymentTransaction) BeforeCreate(tx *gorm.DB) (err error) {
+	pt.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+/* Refund statuses */
+const (
+	RefundStatusRequested = "REQUESTED"
+	RefundStatusRejected  = "REJECTED"
+)
+
+/*
+Refund records a refund request sent to Pesapal for a completed order.
+Pesapal processes refunds asynchronously; once the money is returned the
+order itself moves to REVERSED through the usual IPN flow.
+*/
+type Refund struct {
+	ID               uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrderID          uuid.UUID `gorm:"type:uuid;not null;index" json:"order_id"`
+	Amount           float64   `gorm:"type:numeric(12,2);not null" json:"amount"`
+	ConfirmationCode string    `gorm:"size:100;not null" json:"confirmation_code"`
+	Remarks          string    `gorm:"type:text;not null" json:"remarks"`
+	RequestedBy      uuid.UUID `gorm:"type:uuid;not null" json:"requested_by"`
+	Status           string    `gorm:"size:20;not null" json:"status"`
+	Message          string    `gorm:"type:text" json:"message"`
+	CreatedAt        time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new Refund record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (r *Refund) BeforeCreate(tx *gorm.DB) (err error) {
+	r.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
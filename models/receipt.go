@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+Receipt is issued once for every order that completes. Receipts are numbered
+from a database sequence so the numbers are unique and never reused.
+*/
+type Receipt struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Sequence         int64      `gorm:"autoIncrement;uniqueIndex;not null" json:"-"`
+	Number           string     `gorm:"-" json:"number"` /* Derived from Sequence, see AfterFind */
+	OrderID          uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex" json:"order_id"`
+	UserID           *uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	Amount           float64    `gorm:"type:numeric(12,2);not null" json:"amount"`
+	Currency         string     `gorm:"size:3;not null" json:"currency"`
+	Description      string     `gorm:"size:100" json:"description"`
+	ConfirmationCode string     `gorm:"size:100" json:"confirmation_code"`
+	PaymentMethod    string     `gorm:"size:50" json:"payment_method"`
+	PaidAt           time.Time  `gorm:"not null" json:"paid_at"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime" json:"created_at"`
+
+	/* Relationships */
+	Order Order `gorm:"foreignKey:OrderID" json:"-"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new Receipt record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (r *Receipt) BeforeCreate(tx *gorm.DB) (err error) {
+	r.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// AfterCreate is a GORM hook that fills in the receipt number once the
+// database has assigned the sequence.
+func (r *Receipt) AfterCreate(tx *gorm.DB) (err error) {
+	r.setNumber()
+	return nil
+}
+
+// AfterFind is a GORM hook that fills in the receipt number of loaded receipts.
+func (r *Receipt) AfterFind(tx *gorm.DB) (err error) {
+	r.setNumber()
+	return nil
+}
+
+/* setNumber formats the receipt number, e.g. RCT-2026-000042 */
+func (r *Receipt) setNumber() {
+	r.Number = fmt.Sprintf("RCT-%d-%06d", r.CreatedAt.In(config.EAT).Year(), r.Sequence)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+RecoveryCode is a single-use code that replaces a TOTP code when the user has
+lost their authenticator. Only a hash is stored; the codes are shown once,
+when two-factor authentication is enabled or the codes are regenerated.
+*/
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+
+	/* Relationships */
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new RecoveryCode record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (rc *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	rc.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
@@ -1,10 +1,16 @@
 package models
 
 import (
+	"errors"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 // WebCrawlerResource represents a resource crawled from the web.
@@ -21,9 +27,17 @@ import (
 // - DjangoRelativePath: A unique relative path used in a Django application.
 // - GoogleCloudStorageLink: A link to the resource stored in Google Cloud Storage.
 // - CreatedAt: The timestamp when the resource was created.
-// - Categories: A list of categories associated with the resource (requires github.com/lib/pq).
+// - Categories: The classification's labels, e.g. ["Grade 4", "Mathematics", "End-Term", "Term 2", "2024"].
 // - IsExtracted: A boolean indicating whether the resource's content has been extracted.
 // - ExtractedContent: The extracted content of the resource, if available.
+// - Level, Subject, ResourceType, Term, Year: What utils.ClassifyResource derived from the name and parent directory; empty or 0 when unknown.
+// - ClassifierVersion: The utils.ClassifierVersion that set them.
+// - ManuallyClassified: Set when an admin recategorized the resource; the classifier then leaves its labels alone.
+// - DeletedAt: Set when an admin deletes the resource; deleted resources are hidden from every query but can be restored by importing them again.
+//
+// The table also has a search_vector column used for full-text search. It is
+// maintained by a database trigger (see database.migrateResourceSearch) and
+// deliberately left out of the struct so it is never selected or overwritten.
 type WebCrawlerResource struct {
 	ID                      uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
 	ParentURL               string         `gorm:"type:text" json:"parent_url"`
@@ -37,4 +51,156 @@ type WebCrawlerResource struct {
 	Categories              pq.StringArray `gorm:"type:varchar(255)[]" json:"categories"` // Requires github.com/lib/pq
 	IsExtracted             bool           `json:"is_extracted"`
 	ExtractedContent        string         `gorm:"type:text" json:"extracted_content"`
+	Level                   string         `gorm:"type:varchar(20);not null;default:''" json:"level"`
+	Subject                 string         `gorm:"type:varchar(60);not null;default:''" json:"subject"`
+	ResourceType            string         `gorm:"type:varchar(40);not null;default:''" json:"resource_type"`
+	Term                    int            `gorm:"type:smallint;not null;default:0" json:"term"`
+	Year                    int            `gorm:"type:smallint;not null;default:0" json:"year"`
+	ClassifierVersion       int            `gorm:"type:smallint;not null;default:0" json:"-"`
+	ManuallyClassified      bool           `gorm:"not null;default:false" json:"manually_classified"`
+	DeletedAt               gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Classify sets the resource's level, subject, type, term, year and
+// categories from its name and parent directory.
+func (r *WebCrawlerResource) Classify() {
+	classification := utils.ClassifyResource(r.Name, r.ParentDirectory)
+	r.Level = classification.Level
+	r.Subject = classification.Subject
+	r.ResourceType = classification.Type
+	r.Term = classification.Term
+	r.Year = classification.Year
+	r.Categories = classification.Labels()
+	r.ClassifierVersion = utils.ClassifierVersion
+}
+
+/* Classification returns the resource's current labels */
+func (r *WebCrawlerResource) Classification() utils.ResourceClassification {
+	return utils.ResourceClassification{Level: r.Level, Subject: r.Subject, Type: r.ResourceType, Term: r.Term, Year: r.Year}
+}
+
+// BeforeSave is a GORM hook that is triggered before a WebCrawlerResource is
+// created or saved. It classifies the resource so the facet columns follow
+// its name and parent directory, unless an admin set them by hand.
+func (r *WebCrawlerResource) BeforeSave(tx *gorm.DB) (err error) {
+	if r.ManuallyClassified {
+		r.Categories = r.Classification().Labels()
+		return nil
+	}
+	r.Classify()
+	return nil
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new WebCrawlerResource
+// is created. It sets CreatedAt to the current time in EAT unless the resource
+// already has one, e.g. from an import.
+func (r *WebCrawlerResource) BeforeCreate(tx *gorm.DB) (err error) {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().In(config.EAT)
+	}
+	return nil
+}
+
+/*
+ResourceInput is the metadata admins provide for a resource, in a create or
+update request or as one row of a bulk import.
+*/
+type ResourceInput struct {
+	Name                    string `json:"name"`
+	ParentURL               string `json:"parent_url"`
+	ParentDirectory         string `json:"parent_directory"`
+	RelativePath            string `json:"relative_path"`
+	DjangoRelativePath      string `json:"django_relative_path"` /* Identifies the resource in imports */
+	GoogleDriveDownloadLink string `json:"google_drive_download_link"`
+	GoogleCloudStorageLink  string `json:"google_cloud_storage_link"`
+	ExtractedContent        string `json:"extracted_content"`
+	IsExtracted             *bool  `json:"is_extracted"` /* Defaults to whether ExtractedContent is set */
+}
+
+/* NewResourceInput returns a resource's current metadata, for updates that change only some of it */
+func NewResourceInput(r *WebCrawlerResource) ResourceInput {
+	isExtracted := r.IsExtracted
+	return ResourceInput{
+		Name:                    r.Name,
+		ParentURL:               r.ParentURL,
+		ParentDirectory:         r.ParentDirectory,
+		RelativePath:            r.RelativePath,
+		DjangoRelativePath:      r.DjangoRelativePath,
+		GoogleDriveDownloadLink: r.GoogleDriveDownloadLink,
+		GoogleCloudStorageLink:  r.GoogleCloudStorageLink,
+		ExtractedContent:        r.ExtractedContent,
+		IsExtracted:             &isExtracted,
+	}
+}
+
+// Validate trims the input and checks that it describes a usable resource:
+// the name, Django path and Drive link are required (the path and link are
+// unique per resource) and links must be http(s) URLs.
+//
+// Returns:
+//   - error: The first problem found, or nil if the input is valid.
+func (in *ResourceInput) Validate() error {
+	for _, field := range []*string{&in.Name, &in.ParentURL, &in.ParentDirectory, &in.RelativePath,
+		&in.DjangoRelativePath, &in.GoogleDriveDownloadLink, &in.GoogleCloudStorageLink} {
+		*field = strings.TrimSpace(*field)
+	}
+
+	switch {
+	case in.Name == "":
+		return errors.New("name is required")
+	case in.DjangoRelativePath == "":
+		return errors.New("django_relative_path is required")
+	case in.GoogleDriveDownloadLink == "":
+		return errors.New("google_drive_download_link is required")
+	}
+
+	for name, link := range map[string]string{
+		"parent_url":                 in.ParentURL,
+		"google_drive_download_link": in.GoogleDriveDownloadLink,
+		"google_cloud_storage_link":  in.GoogleCloudStorageLink,
+	} {
+		if link == "" {
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New(name + " must be an http(s) URL")
+		}
+	}
+	return nil
 }
+
+/* ApplyTo copies the input onto a resource */
+func (in *ResourceInput) ApplyTo(r *WebCrawlerResource) {
+	r.Name = in.Name
+	r.ParentURL = in.ParentURL
+	r.ParentDirectory = in.ParentDirectory
+	r.RelativePath = in.RelativePath
+	r.DjangoRelativePath = in.DjangoRelativePath
+	r.GoogleDriveDownloadLink = in.GoogleDriveDownloadLink
+	r.GoogleCloudStorageLink = in.GoogleCloudStorageLink
+	r.ExtractedContent = in.ExtractedContent
+	if in.IsExtracted != nil {
+		r.IsExtracted = *in.IsExtracted
+	} else {
+		r.IsExtracted = in.ExtractedContent != ""
+	}
+}
+
+/*
+ResourceCategoriesInput sets a resource's labels by hand. Omitted fields keep
+their current value; Automatic hands the resource back to the classifier.
+*/
+type ResourceCategoriesInput struct {
+	Level     string `json:"level"`
+	Subject   string `json:"subject"`
+	Type      string `json:"type"`
+	Term      int    `json:"term"`
+	Year      int    `json:"year"`
+	Automatic bool   `json:"automatic"`
+}
+
+/* Text search configuration used to index and query resources */
+const ResourceSearchConfig = "english"
+
+/* Characters of extracted text indexed per resource; the rest is not searchable */
+const ResourceSearchContentLimit = 200000
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+SearchSynonym expands an abbreviation or alternative spelling students type
+into the words used in resource names, e.g. "cre" into "christian religious
+education". A term may be several words ("pp 1"). Searches match either the
+term or its expansion.
+*/
+type SearchSynonym struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Term      string    `gorm:"size:100;not null;uniqueIndex" json:"term"` /* Lowercase */
+	Expansion string    `gorm:"size:255;not null" json:"expansion"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new SearchSynonym record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (s *SearchSynonym) BeforeCreate(tx *gorm.DB) (err error) {
+	s.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+/*
+DefaultSearchSynonyms are added when the search_synonyms table is set up.
+Terms ending in a letter also match when glued to a number ("f2", "grd4").
+*/
+var DefaultSearchSynonyms = map[string]string{
+	/* Levels */
+	"f":             "form",
+	"frm":           "form",
+	"g":             "grade",
+	"gr":            "grade",
+	"grd":           "grade",
+	"std":           "standard",
+	"pp 1":          "pp1",
+	"pp 2":          "pp2",
+	"pre primary 1": "pp1",
+	"pre primary 2": "pp2",
+	"jss":           "junior secondary",
+	"sss":           "senior school",
+
+	/* Subjects */
+	"cre":   "christian religious education",
+	"ire":   "islamic religious education",
+	"hre":   "hindu religious education",
+	"kisw":  "kiswahili",
+	"kis":   "kiswahili",
+	"eng":   "english",
+	"math":  "mathematics",
+	"maths": "mathematics",
+	"sci":   "science",
+	"sst":   "social studies",
+	"bio":   "biology",
+	"chem":  "chemistry",
+	"phy":   "physics",
+	"geo":   "geography",
+	"hist":  "history",
+	"agri":  "agriculture",
+	"bst":   "business studies",
+	"comp":  "computer studies",
+	"hsc":   "home science",
+
+	/* Resource types */
+	"qp":   "question paper",
+	"ms":   "marking scheme",
+	"qns":  "questions",
+	"ans":  "answers",
+	"revn": "revision",
+}
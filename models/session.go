@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+Session is one logged-in device. Access tokens carry the session ID and are
+only accepted while the session is active; the refresh token, stored hashed,
+is rotated every time it is used.
+*/
+type Session struct {
+	ID                       uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID                   uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	RefreshTokenHash         string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	PreviousRefreshTokenHash string     `gorm:"size:64;index" json:"-"` /* Detects reuse of a rotated token */
+	Device                   string     `gorm:"size:100" json:"device"`
+	IPAddress                string     `gorm:"size:45" json:"ip_address"`
+	UserAgent                string     `gorm:"type:text" json:"user_agent"`
+	LastSeenAt               time.Time  `json:"last_seen_at"`
+	ExpiresAt                time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt                *time.Time `gorm:"index" json:"revoked_at"`
+	CreatedAt                time.Time  `gorm:"autoCreateTime" json:"created_at"`
+
+	/* Relationships */
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new Session record
+// is created in the database. It sets the CreatedAt and LastSeenAt fields to the
+// current time in the East Africa Time (EAT) timezone.
+func (s *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	s.CreatedAt = time.Now().In(config.EAT)
+	s.LastSeenAt = s.CreatedAt
+	return nil
+}
+
+// IsActive reports whether the session has been neither revoked nor expired.
+func (s *Session) IsActive(at time.Time) bool {
+	return s.RevokedAt == nil && at.Before(s.ExpiresAt)
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+/*
+SubscriptionPlan describes a premium plan that can be bought through Pesapal.
+Recurring plans are charged automatically by Pesapal every Frequency period.
+*/
+type SubscriptionPlan struct {
+	Code      string  `json:"code"`
+	Name      string  `json:"name"`
+	Price     float64 `json:"price"`
+	Currency  string  `json:"currency"`
+	Days      int     `json:"days"`
+	Months    int     `json:"months"`
+	Recurring bool    `json:"recurring"`
+	Frequency string  `json:"frequency,omitempty"`
+}
+
+/* Plans on sale, keyed by plan code. Prices are in KES. */
+var SubscriptionPlans = map[string]SubscriptionPlan{
+	"daily":   {Code: "daily", Name: "Daily Access", Price: 50, Currency: "KES", Days: 1},
+	"weekly":  {Code: "weekly", Name: "Weekly Access", Price: 250, Currency: "KES", Days: 7},
+	"termly":  {Code: "termly", Name: "Termly Access", Price: 1500, Currency: "KES", Days: 90},
+	"monthly": {Code: "monthly", Name: "Monthly Auto-Renewing Access", Price: 500, Currency: "KES", Months: 1, Recurring: true, Frequency: "MONTHLY"},
+}
+
+/* ExpiresAfter returns when one period of the plan starting at start ends */
+func (p SubscriptionPlan) ExpiresAfter(start time.Time) time.Time {
+	return start.AddDate(0, p.Months, p.Days)
+}
+
+/* Subscription statuses */
+const (
+	SubscriptionStatusActive  = "active"
+	SubscriptionStatusRevoked = "revoked"
+)
+
+/* Subscription grants premium access to a user for a period of time */
+type Subscription struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	OrderID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"order_id"`
+	PlanCode  string    `gorm:"size:20;not null" json:"plan_code"`
+	Status    string    `gorm:"size:20;not null;default:'active'" json:"status"`
+	AutoRenew bool      `gorm:"default:false" json:"auto_renew"`
+	StartsAt  time.Time `gorm:"not null" json:"starts_at"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	/* Relationships */
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// BeforeCreate is a GORM hook that is triggered before a new Subscription record
+// is created in the database. It sets the CreatedAt field to the current time
+// in the East Africa Time (EAT) timezone.
+func (s *Subscription) BeforeCreate(tx *gorm.DB) (err error) {
+	s.CreatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// BeforeUpdate is a GORM hook that is triggered before updating a Subscription record.
+// It updates the UpdatedAt field with the current time in the configured EAT timezone.
+func (s *Subscription) BeforeUpdate(tx *gorm.DB) (err error) {
+	s.UpdatedAt = time.Now().In(config.EAT)
+	return nil
+}
+
+// IsActive reports whether the subscription grants access at the given time.
+func (s *Subscription) IsActive(at time.Time) bool {
+	return s.Status == SubscriptionStatusActive && !at.Before(s.StartsAt) && at.Before(s.ExpiresAt)
+}
@@ -1,26 +1,70 @@
 package models
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/bot-on-tapwater/cbcexams-backend/config"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+/* User roles; admin and tutor can only be granted by an admin */
+const (
+	RoleStudent = "student"
+	RoleParent  = "parent"
+	RoleTeacher = "teacher"
+	RoleTutor   = "tutor"
+	RoleSchool  = "school"
+	RoleAdmin   = "admin"
+)
+
+/*
+Roles users may pick for themselves when registering. Tutors see students'
+contact details, so the role is granted by an admin once their application
+has been reviewed.
+*/
+var SelfAssignableRoles = []string{RoleStudent, RoleParent, RoleTeacher, RoleSchool}
+
+// IsValidRole reports whether role is one of the known user roles.
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleTutor || IsSelfAssignableRole(role)
+}
+
+// IsSelfAssignableRole reports whether a user may choose role at registration.
+func IsSelfAssignableRole(role string) bool {
+	for _, r := range SelfAssignableRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
 	ID                   uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
-	Email                string     `gorm:"unique;not null" json:"email"`
-	Password             string     `gorm:"not null" json:"password"` /* Hidden in JSON responses */
+	Email                string     `gorm:"not null;default:'';uniqueIndex:idx_users_email,where:email <> ''" json:"email"` /* Empty for phone-only accounts */
+	Password             string     `gorm:"not null" json:"-"`                                                              /* Hidden in JSON responses */
 	FirstName            string     `gorm:"size:100" json:"first_name"`
 	LastName             string     `gorm:"size:100" json:"last_name"`
-	IsActive             bool       `gorm:"default:false" json:"is_active"`
+	PhoneNumber          string     `gorm:"size:20;uniqueIndex:idx_users_phone_number,where:phone_number <> ''" json:"phone_number"` /* E.164 */
+	PhoneVerifiedAt      *time.Time `json:"phone_verified_at"`
+	IsActive             bool       `gorm:"default:false" json:"is_active"` /* Set once the email address is verified */
+	EmailVerifiedAt      *time.Time `json:"email_verified_at"`
+	PendingEmail         string     `gorm:"size:255;not null;default:''" json:"pending_email"` /* A new address waiting for its link to be confirmed */
+	Role                 string     `gorm:"size:20;not null;default:'student';index" json:"role"`
 	LastLogin            time.Time  `json:"last_login"`
-	PasswordResetToken   string     `gorm:"size:255" json:"password_reset_token"`
-	PasswordResetExpires time.Time  `json:"password_reset_expires"`
-	Bookmarks            []Bookmark `gorm:"foreignKey:UserID"`
+	FailedLoginAttempts  int        `gorm:"not null;default:0" json:"-"` /* Consecutive, reset on success */
+	LockedUntil          *time.Time `json:"-"`
+	TOTPSecret           string     `gorm:"size:64" json:"-"` /* Set at enrollment; only used once TOTPEnabledAt is set */
+	TOTPEnabledAt        *time.Time `json:"-"`
+	TOTPLastStep         int64      `gorm:"not null;default:0" json:"-"` /* Last accepted time step, so a code cannot be replayed */
+	PasswordResetToken   string     `gorm:"size:255" json:"-"`           /* SHA-256 of the emailed token */
+	PasswordResetExpires time.Time  `json:"-"`
+	DeletionScheduledFor *time.Time `gorm:"index" json:"-"` /* Set by a deletion request; cleared if the user logs in before then */
+	AnonymizedAt         *time.Time `json:"-"`              /* Set once the account's personal data has been erased */
+	Bookmarks            []Bookmark `gorm:"foreignKey:UserID" json:"-"`
 }
 
 // BeforeLastLogin is a GORM hook that is triggered before updating the LastLogin field of a User.
@@ -41,7 +85,6 @@ func (u *User) BeforeLastLogin(tx gorm.DB) (err error) {
 // and updates the Password field with the hashed value. It returns an error
 // if the hashing process fails.
 func (u *User) HashPassword() error {
-	fmt.Printf("Hashing the password: %s\n", u.Password)
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 
 	if err != nil {
@@ -68,9 +111,10 @@ func (u *User) CheckPassword(password string) error {
 }
 
 type UpdateProfileInput struct {
-	FirstName string `json:"first_name,omitempty"`
-	LastName  string `json:"last_name,omitempty"`
-	Email     string `json:"email,omitempty" validate:"omitempty, email"`
+	FirstName   string `json:"first_name,omitempty"`
+	LastName    string `json:"last_name,omitempty"`
+	Email       string `json:"email,omitempty" validate:"omitempty, email"`
+	PhoneNumber string `json:"phone_number,omitempty"`
 }
 
 func (u *User) UpdateProfile(input UpdateProfileInput) error {
@@ -82,8 +126,97 @@ func (u *User) UpdateProfile(input UpdateProfileInput) error {
 		u.LastName = input.LastName
 	}
 
-	if input.Email != "" && input.Email != u.Email {
-		u.Email = input.Email
+	/*
+		A new email address only replaces the current one once its link is
+		confirmed, so the account keeps a verified address in the meantime
+	*/
+	if input.Email != "" {
+		if input.Email == u.Email {
+			u.PendingEmail = ""
+		} else {
+			u.PendingEmail = input.Email
+		}
+	}
+
+	if input.PhoneNumber != "" {
+		phone, err := utils.NormalizeKenyanPhone(input.PhoneNumber)
+		if err != nil {
+			return err
+		}
+		if phone != u.PhoneNumber {
+			u.PhoneNumber = phone
+			u.PhoneVerifiedAt = nil
+		}
 	}
 	return nil
 }
+
+/* RegisterInput is the request body of AuthController.Register */
+type RegisterInput struct {
+	Email       string `json:"email" binding:"omitempty,email"`
+	Password    string `json:"password"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	PhoneNumber string `json:"phone_number"`
+	Role        string `json:"role"`
+}
+
+/*
+UserResponse is what the API shows of a user. Handlers return it instead of
+User so that password hashes, reset tokens and 2FA secrets never reach a
+response, even when new fields are added to User.
+*/
+type UserResponse struct {
+	ID                   uuid.UUID  `json:"id"`
+	Email                string     `json:"email"`
+	FirstName            string     `json:"first_name"`
+	LastName             string     `json:"last_name"`
+	PhoneNumber          string     `json:"phone_number"`
+	PhoneVerifiedAt      *time.Time `json:"phone_verified_at"`
+	EmailVerifiedAt      *time.Time `json:"email_verified_at"`
+	PendingEmail         string     `json:"pending_email"`
+	IsActive             bool       `json:"is_active"`
+	Role                 string     `json:"role"`
+	LastLogin            time.Time  `json:"last_login"`
+	TwoFactorEnabled     bool       `json:"two_factor_enabled"`
+	HasPassword          bool       `json:"has_password"`
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for"`
+}
+
+/*
+AdminUserResponse adds account security state that only admins see. Whether
+the account is locked is shown, not the failure count or lock expiry, which
+would tell an attacker how many guesses remain.
+*/
+type AdminUserResponse struct {
+	UserResponse
+	Locked bool `json:"locked"`
+}
+
+// Response returns the public view of the user.
+func (u *User) Response() UserResponse {
+	return UserResponse{
+		ID:                   u.ID,
+		Email:                u.Email,
+		FirstName:            u.FirstName,
+		LastName:             u.LastName,
+		PhoneNumber:          u.PhoneNumber,
+		PhoneVerifiedAt:      u.PhoneVerifiedAt,
+		EmailVerifiedAt:      u.EmailVerifiedAt,
+		PendingEmail:         u.PendingEmail,
+		IsActive:             u.IsActive,
+		Role:                 u.Role,
+		LastLogin:            u.LastLogin,
+		TwoFactorEnabled:     u.TOTPEnabledAt != nil,
+		HasPassword:          u.Password != "",
+		DeletionScheduledFor: u.DeletionScheduledFor,
+	}
+}
+
+// AdminResponse returns the view of the user shown in admin listings.
+func (u *User) AdminResponse() AdminUserResponse {
+	return AdminUserResponse{
+		UserResponse: u.Response(),
+		Locked:       u.LockedUntil != nil && time.Now().Before(*u.LockedUntil),
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Package mock is a fake OpenID Connect provider for offline development and
+tests of the "Sign in with Google" flow.
+
+It serves the discovery document, an authorization endpoint, a token endpoint
+and a JWKS endpoint, and signs ID tokens with an RSA key generated at start-up.
+Point GOOGLE_ISSUER at a running mock provider to sign in without Google.
+
+The authorization endpoint shows a small form asking which identity to sign
+in as. Tests can skip the form by adding the identity to the authorization
+URL:
+  - email:          the email address to sign in as
+  - name:           full name (optional, defaults to the part before the @)
+  - email_verified: "false" to simulate an unverified address
+*/
+package mock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/* Key ID of the signing key published on the JWKS endpoint */
+const keyID = "mock-key-1"
+
+type Options struct {
+	Issuer       string /* Public URL of the mock provider, e.g. http://localhost:8091 */
+	ClientID     string /* Accepted client ID (any client if empty) */
+	ClientSecret string /* Accepted client secret (any secret if empty) */
+}
+
+/* authorization is an issued authorization code waiting to be exchanged */
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	email         string
+	name          string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type Server struct {
+	opts  Options
+	key   *rsa.PrivateKey
+	mux   *http.ServeMux
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// NewServer returns a mock provider ready to be served with net/http.
+func NewServer(opts Options) *Server {
+	opts.Issuer = strings.TrimSuffix(opts.Issuer, "/")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("mock oidc: failed to generate signing key: %v", err)
+	}
+
+	s := &Server{opts: opts, key: key, mux: http.NewServeMux(), codes: make(map[string]authorization)}
+	s.mux.HandleFunc("GET /.well-known/openid-configuration", s.handleDiscovery)
+	s.mux.HandleFunc("GET /authorize", s.handleAuthorize)
+	s.mux.HandleFunc("POST /token", s.handleToken)
+	s.mux.HandleFunc("GET /jwks", s.handleJWKS)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.opts.Issuer,
+		"authorization_endpoint":                s.opts.Issuer + "/authorize",
+		"token_endpoint":                        s.opts.Issuer + "/token",
+		"jwks_uri":                              s.opts.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; max-width: 420px; margin: 60px auto;">
+  <h2>Mock identity provider</h2>
+  <form method="GET" action="/authorize">
+    {{range $key, $values := .Params}}{{range $values}}<input type="hidden" name="{{$key}}" value="{{.}}">{{end}}{{end}}
+    <p><label>Email<br><input name="email" type="email" required style="width: 100%"></label></p>
+    <p><label>Name<br><input name="name" style="width: 100%"></label></p>
+    <p><label><input type="checkbox" name="email_verified" value="false"> Email is not verified</label></p>
+    <button type="submit">Sign in</button>
+  </form>
+</body>
+</html>`))
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	redirectURI := q.Get("redirect_uri")
+
+	if q.Get("response_type") != "code" || redirectURI == "" {
+		http.Error(w, "response_type=code and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	if s.opts.ClientID != "" && clientID != s.opts.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	email := q.Get("email")
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginForm.Execute(w, map[string]url.Values{"Params": q})
+		return
+	}
+
+	name := q.Get("name")
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	code := randomHex(16)
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		clientID:      clientID,
+		redirectURI:   redirectURI,
+		nonce:         q.Get("nonce"),
+		email:         email,
+		name:          name,
+		emailVerified: q.Get("email_verified") != "false",
+		expiresAt:     time.Now().Add(5 * time.Minute),
+	}
+	s.mu.Unlock()
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	target.RawQuery = params.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	clientSecret := r.PostForm.Get("client_secret")
+	if user, pass, ok := r.BasicAuth(); ok {
+		clientID, clientSecret = user, pass
+	}
+	if (s.opts.ClientID != "" && clientID != s.opts.ClientID) || (s.opts.ClientSecret != "" && clientSecret != s.opts.ClientSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	/* Codes are single use */
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	auth, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok || time.Now().After(auth.expiresAt) ||
+		auth.clientID != clientID || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	givenName, familyName, _ := strings.Cut(auth.name, " ")
+	subject := sha256.Sum256([]byte(strings.ToLower(auth.email)))
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.opts.Issuer,
+		"aud":            clientID,
+		"sub":            hex.EncodeToString(subject[:10]),
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+		"name":           auth.name,
+		"given_name":     givenName,
+		"family_name":    familyName,
+		"nonce":          auth.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomHex(16),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("mock oidc: failed to write response: %v", err)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("mock oidc: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,306 @@
+/*
+Package oidc implements the parts of OpenID Connect needed to sign users in
+with an external identity provider such as Google: discovery, the
+authorization code flow and RS256 ID token verification against the
+provider's published keys.
+
+Only the standard endpoints are used, so the same code works against Google
+and against the local mock provider in oidc/mock.
+*/
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/* How long discovery documents and signing keys are reused before refetching */
+const keyCacheTTL = time.Hour
+
+var defaultHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+/* Provider is an OpenID Connect identity provider registered for this app */
+type Provider struct {
+	Issuer       string /* e.g. https://accounts.google.com */
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string     /* Defaults to openid, email and profile */
+	HTTPClient   *http.Client /* Optional; defaults to a client with a timeout */
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+/* TokenResponse is the token endpoint's answer to an authorization code exchange */
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+/* Claims are the identity claims read from a verified ID token */
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+// Configured reports whether the provider has the settings needed for a login.
+func (p *Provider) Configured() bool {
+	return p.Issuer != "" && p.ClientID != "" && p.ClientSecret != "" && p.RedirectURL != ""
+}
+
+// AuthCodeURL returns the URL to send the user to for signing in.
+//
+// Parameters:
+//   - state: Opaque value echoed back to the redirect URL, used against CSRF.
+//   - nonce: Value the provider embeds in the ID token, used against replay.
+//
+// Returns:
+//   - string: The authorization URL.
+//   - error: An error if the discovery document cannot be fetched.
+func (p *Provider) AuthCodeURL(state, nonce string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	return doc.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens at the token endpoint.
+func (p *Provider) Exchange(code string) (*TokenResponse, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	resp, err := p.httpClient().PostForm(doc.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens TokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken checks an ID token's RS256 signature against the provider's
+// published keys and validates its issuer, audience, expiry and nonce.
+//
+// Parameters:
+//   - rawIDToken: The id_token returned by Exchange.
+//   - nonce: The nonce sent in the authorization request.
+//
+// Returns:
+//   - *Claims: The verified identity claims.
+//   - error: An error if the token fails any check.
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string) (*Claims, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, p.keyFunc,
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %v", err)
+	}
+
+	if !issuerMatches(claims.Issuer, doc.Issuer) {
+		return nil, fmt.Errorf("invalid id_token: issuer %q does not match %q", claims.Issuer, doc.Issuer)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid id_token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid id_token: missing subject")
+	}
+	return claims, nil
+}
+
+// issuerMatches reports whether an ID token's iss claim names the provider.
+// Google issues tokens with either "https://accounts.google.com" or the bare
+// "accounts.google.com", so the scheme-less form of an https issuer is
+// accepted too.
+func issuerMatches(tokenIssuer, issuer string) bool {
+	return tokenIssuer == issuer ||
+		(strings.HasPrefix(issuer, "https://") && tokenIssuer == strings.TrimPrefix(issuer, "https://"))
+}
+
+/* keyFunc returns the provider key that signed a token, refetching the key set once for unknown key IDs */
+func (p *Provider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		keys, err := p.signingKeys(attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		/* A single key without a kid is unambiguous */
+		if kid == "" && len(keys) == 1 {
+			for _, key := range keys {
+				return key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+/* discover fetches and caches the provider's discovery document */
+func (p *Provider) discover() (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.fetchedAt) < keyCacheTTL {
+		return p.discovery, nil
+	}
+
+	var doc discoveryDocument
+	if err := p.getJSON(strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("discovery failed: %v", err)
+	}
+	if doc.Issuer != strings.TrimSuffix(p.Issuer, "/") && doc.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", doc.Issuer, p.Issuer)
+	}
+
+	p.discovery = &doc
+	p.keys = nil
+	p.fetchedAt = time.Now()
+	return p.discovery, nil
+}
+
+/* signingKeys returns the provider's RSA keys by key ID, fetching them when missing or when refresh is set */
+func (p *Provider) signingKeys(refresh bool) (map[string]*rsa.PublicKey, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && !refresh {
+		return p.keys, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(doc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.keys = keys
+	return keys, nil
+}
+
+func (p *Provider) getJSON(endpoint string, out interface{}) error {
+	resp, err := p.httpClient().Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
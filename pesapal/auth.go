@@ -13,8 +13,20 @@ type AuthResponse struct {
 	ExpiryDate string `json:"expiryDate"`
 }
 
+// Authenticate requests a new bearer token from Pesapal.
+// Prefer Client.Token, which reuses the token until it expires.
 func (c *Config) Authenticate() (string, error) {
-	client := &http.Client{}
+	authResp, err := c.RequestToken()
+	if err != nil {
+		return "", err
+	}
+	return authResp.Token, nil
+}
+
+// RequestToken requests a new bearer token from Pesapal and returns it
+// together with its expiry date.
+func (c *Config) RequestToken() (*AuthResponse, error) {
+	client := c.httpClient()
 	url := fmt.Sprintf("%s/api/Auth/RequestToken", c.BaseURL)
 
 	/* Create the request body with consumer_key and consumer_secret */
@@ -24,12 +36,12 @@ func (c *Config) Authenticate() (string, error) {
 	}
 	bodyBytes, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("error marshalling request body: %v", err)
+		return nil, fmt.Errorf("error marshalling request body: %v", err)
 	}
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
 	req.Header.Add("Content-Type", "application/json")
@@ -38,24 +50,29 @@ func (c *Config) Authenticate() (string, error) {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("error making request: %v", err)
+		return nil, fmt.Errorf("error making request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// if resp.StatusCode != http.StatusOK {
-	// 	return "", fmt.Errorf("authentication failed with status: %s", resp.Status)
+	// 	return nil, fmt.Errorf("authentication failed with status: %s", resp.Status)
 	// }
 
 	if resp.StatusCode != http.StatusOK {
 		// Read the response body for more details
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("authentication failed with status: %s, response: %s", resp.Status, string(body))
+		return nil, fmt.Errorf("authentication failed with status: %s, response: %s", resp.Status, string(body))
 	}
 
 	var authResp AuthResponse
 	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return "", fmt.Errorf("error decoding response: %v", err)
+		return nil, fmt.Errorf("error decoding response: %v", err)
 	}
 
-	return authResp.Token, nil
+	/* Pesapal reports bad credentials with a 200 and an empty token */
+	if authResp.Token == "" {
+		return nil, fmt.Errorf("authentication failed: no token in response")
+	}
+
+	return &authResp, nil
 }
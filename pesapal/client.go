@@ -0,0 +1,151 @@
+package pesapal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Refresh tokens this long before Pesapal says they expire,
+so a token never runs out in the middle of a request.
+*/
+const tokenExpiryMargin = time.Minute
+
+/* Lifetime assumed when Pesapal's expiryDate cannot be parsed */
+const defaultTokenLifetime = 5 * time.Minute
+
+// Client wraps a Config with the state that should be shared across requests:
+// the bearer token (reused until shortly before its ExpiryDate) and the IPN ID
+// (registered once per process). All methods are safe for concurrent use, so a
+// single Client can be shared by every handler.
+type Client struct {
+	Config *Config
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	ipnMu sync.Mutex
+	ipnID string
+}
+
+// NewClient returns a Client for the given configuration.
+// The configured IPN ID, if any, is used instead of registering a new IPN.
+func NewClient(config *Config) *Client {
+	return &Client{
+		Config: config,
+		ipnID:  config.IPNID,
+	}
+}
+
+// Token returns a valid bearer token, requesting a new one from Pesapal only
+// when there is no cached token or the cached one is about to expire.
+func (cl *Client) Token() (string, error) {
+	cl.tokenMu.Lock()
+	defer cl.tokenMu.Unlock()
+
+	if cl.token != "" && time.Now().Before(cl.tokenExpiry.Add(-tokenExpiryMargin)) {
+		return cl.token, nil
+	}
+
+	authResp, err := cl.Config.RequestToken()
+	if err != nil {
+		return "", err
+	}
+
+	expiry, err := time.Parse(time.RFC3339Nano, authResp.ExpiryDate)
+	if err != nil {
+		expiry = time.Now().Add(defaultTokenLifetime)
+	}
+
+	cl.token = authResp.Token
+	cl.tokenExpiry = expiry
+	return cl.token, nil
+}
+
+// IPNID returns the ID of the IPN registered for Config.IPNURL.
+// It reuses, in order: the ID already known to the client (from PESAPAL_IPN_ID
+// or an earlier call), an active IPN with the same URL from GetIpnList, and
+// finally registers the URL with Pesapal. The result is cached for the
+// lifetime of the client.
+func (cl *Client) IPNID() (string, error) {
+	cl.ipnMu.Lock()
+	defer cl.ipnMu.Unlock()
+
+	if cl.ipnID != "" {
+		return cl.ipnID, nil
+	}
+
+	token, err := cl.Token()
+	if err != nil {
+		return "", err
+	}
+
+	/* Reuse an IPN registered by an earlier run */
+	ipnList, err := cl.Config.GetIpnList(token)
+	if err == nil {
+		for _, ipn := range ipnList {
+			if ipn.URL == cl.Config.IPNURL && ipn.Status == 1 {
+				cl.ipnID = ipn.ID
+				return cl.ipnID, nil
+			}
+		}
+	}
+
+	ipnResp, err := cl.Config.RegisterIPN(token)
+	if err != nil {
+		return "", err
+	}
+	if ipnResp.ID == "" {
+		return "", fmt.Errorf("IPN registration returned no ipn_id")
+	}
+
+	cl.ipnID = ipnResp.ID
+	return cl.ipnID, nil
+}
+
+// SubmitOrder submits an order using the cached token and IPN ID.
+func (cl *Client) SubmitOrder(order OrderRequest) (*OrderResponse, error) {
+	token, err := cl.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	ipnID, err := cl.IPNID()
+	if err != nil {
+		return nil, err
+	}
+
+	return cl.Config.SubmitOrder(token, ipnID, order)
+}
+
+// GetTransactionStatus fetches a transaction's status using the cached token.
+func (cl *Client) GetTransactionStatus(orderTrackingID string) (*TransactionStatusResponse, error) {
+	token, err := cl.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return cl.Config.GetTransactionStatus(token, orderTrackingID)
+}
+
+// RequestRefund asks Pesapal to refund a completed payment using the cached token.
+func (cl *Client) RequestRefund(refund RefundRequest) (*ActionResponse, error) {
+	token, err := cl.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return cl.Config.RequestRefund(token, refund)
+}
+
+// CancelOrder cancels an unpaid order using the cached token.
+func (cl *Client) CancelOrder(orderTrackingID string) (*ActionResponse, error) {
+	token, err := cl.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return cl.Config.CancelOrder(token, orderTrackingID)
+}
@@ -3,15 +3,22 @@ package pesapal
 import (
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 )
 
+/* Shared HTTP client used when Config.HTTPClient is not set */
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
 type Config struct {
 	ConsumerKey    string
 	ConsumerSecret string
 	BaseURL        string
 	CallbackURL    string
 	IPNURL         string
+	IPNID          string /* Optional: reuse an IPN that is already registered */
+	HTTPClient     *http.Client
 }
 
 func NewConfig() *Config {
@@ -21,7 +28,16 @@ func NewConfig() *Config {
 		BaseURL:        os.Getenv("PESAPAL_BASE_URL"),
 		CallbackURL:    os.Getenv("PESAPAL_CALLBACK_URL"),
 		IPNURL:         os.Getenv("PESAPAL_IPN_URL"),
+		IPNID:          os.Getenv("PESAPAL_IPN_ID"),
+	}
+}
+
+/* httpClient returns the configured HTTP client or the shared default */
+func (c *Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
 	}
+	return defaultHTTPClient
 }
 
 func (c *Config) GetAuthHeader() string {
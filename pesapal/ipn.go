@@ -18,7 +18,7 @@ type IPNResponse struct {
 }
 
 func (c *Config) RegisterIPN(authToken string) (*IPNResponse, error) {
-	client := &http.Client{}
+	client := c.httpClient()
 	url := fmt.Sprintf("%s/api/URLSetup/RegisterIPN", c.BaseURL)
 
 	requestBody := IPNRequest{
@@ -57,3 +57,43 @@ func (c *Config) RegisterIPN(authToken string) (*IPNResponse, error) {
 
 	return &ipnResp, nil
 }
+
+type IPNListItem struct {
+	URL                string `json:"url"`
+	CreatedDate        string `json:"created_date"`
+	ID                 string `json:"ipn_id"`
+	NotificationType   int    `json:"notification_type"`
+	NotificationMethod string `json:"ipn_notification_type_description"`
+	Status             int    `json:"ipn_status"`
+	StatusDescription  string `json:"ipn_status_description"`
+}
+
+func (c *Config) GetIpnList(authToken string) ([]IPNListItem, error) {
+	client := c.httpClient()
+	url := fmt.Sprintf("%s/api/URLSetup/GetIpnList", c.BaseURL)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+authToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IPN list request failed with status: %s", resp.Status)
+	}
+
+	var ipnList []IPNListItem
+	if err := json.NewDecoder(resp.Body).Decode(&ipnList); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	return ipnList, nil
+}
@@ -8,13 +8,36 @@ import (
 )
 
 type OrderRequest struct {
-	ID             string  `json:"id"`
-	Currency       string  `json:"currency"`
-	Amount         float64 `json:"amount"`
-	Description    string  `json:"description"`
-	CallbackURL    string  `json:"callback_url"`
-	NotificationID string  `json:"notification_id"`
-	BillingAddress Address `json:"billing_address"`
+	ID                  string               `json:"id"`
+	Currency            string               `json:"currency"`
+	Amount              float64              `json:"amount"`
+	Description         string               `json:"description"`
+	CallbackURL         string               `json:"callback_url"`
+	NotificationID      string               `json:"notification_id"`
+	BillingAddress      Address              `json:"billing_address"`
+	AccountNumber       string               `json:"account_number,omitempty"`
+	SubscriptionDetails *SubscriptionDetails `json:"subscription_details,omitempty"`
+}
+
+/* Recurring payment frequencies supported by Pesapal */
+const (
+	FrequencyDaily   = "DAILY"
+	FrequencyWeekly  = "WEEKLY"
+	FrequencyMonthly = "MONTHLY"
+	FrequencyYearly  = "YEARLY"
+)
+
+/* Date layout Pesapal expects in subscription_details (dd-MM-yyyy) */
+const SubscriptionDateLayout = "02-01-2006"
+
+/*
+SubscriptionDetails turns an order into a recurring payment.
+Pesapal requires AccountNumber to be set on the order as well.
+*/
+type SubscriptionDetails struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Frequency string `json:"frequency"`
 }
 
 type Address struct {
@@ -38,7 +61,7 @@ type OrderResponse struct {
 }
 
 func (c *Config) SubmitOrder(authToken, ipnID string, order OrderRequest) (*OrderResponse, error) {
-	client := &http.Client{}
+	client := c.httpClient()
 	url := fmt.Sprintf("%s/api/Transactions/SubmitOrderRequest", c.BaseURL)
 
 	/* Set the notification ID from IPN registration */
@@ -0,0 +1,76 @@
+package pesapal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type RefundRequest struct {
+	ConfirmationCode string  `json:"confirmation_code"`
+	Amount           float64 `json:"amount,string"`
+	Username         string  `json:"username"`
+	Remarks          string  `json:"remarks"`
+}
+
+type CancelOrderRequest struct {
+	OrderTrackingID string `json:"order_tracking_id"`
+}
+
+/* Response shared by RefundRequest and CancelOrder; Status is "200" on success */
+type ActionResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+/* Succeeded reports whether Pesapal accepted the request */
+func (r *ActionResponse) Succeeded() bool {
+	return r.Status == "200"
+}
+
+func (c *Config) RequestRefund(authToken string, refund RefundRequest) (*ActionResponse, error) {
+	url := fmt.Sprintf("%s/api/Transactions/RefundRequest", c.BaseURL)
+	return c.postAction(authToken, url, refund)
+}
+
+func (c *Config) CancelOrder(authToken, orderTrackingID string) (*ActionResponse, error) {
+	url := fmt.Sprintf("%s/api/Transactions/CancelOrder", c.BaseURL)
+	return c.postAction(authToken, url, CancelOrderRequest{OrderTrackingID: orderTrackingID})
+}
+
+/* postAction sends a JSON body to an endpoint that answers with an ActionResponse */
+func (c *Config) postAction(authToken, url string, body interface{}) (*ActionResponse, error) {
+	client := c.httpClient()
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+authToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed with status: %s", resp.Status)
+	}
+
+	var actionResp ActionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&actionResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	return &actionResp, nil
+}
@@ -0,0 +1,638 @@
+/*
+Package sandbox is a fake Pesapal API for offline development and tests.
+
+It implements the endpoints used by the pesapal package (RequestToken,
+RegisterIPN, GetIpnList, SubmitOrderRequest, GetTransactionStatus,
+RefundRequest and CancelOrder) in memory, and can fire IPN callbacks at the
+registered IPN URL. Point PESAPAL_BASE_URL at a running sandbox to exercise
+every payment path without live credentials.
+
+How an order is resolved depends on the active scenario:
+  - success: the order completes as soon as it is submitted.
+  - failure: the order fails as soon as it is submitted.
+  - delayed: the order stays pending for Options.Delay, then completes.
+  - manual:  the order stays pending until the redirect URL is opened or the
+    outcome is set through the control endpoints.
+
+Control endpoints (not part of Pesapal's API):
+  - POST /sandbox/scenario                     {"scenario": "failure"}
+  - GET  /sandbox/orders                       list every order and its status
+  - POST /sandbox/orders/{tracking_id}/status  {"status": "COMPLETED"}; fires an IPN
+  - POST /sandbox/orders/{tracking_id}/ipn     re-send the IPNCHANGE notification
+  - POST /sandbox/orders/{tracking_id}/renew   charge a recurring order again; fires a RECURRING IPN
+*/
+package sandbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* Scenarios */
+const (
+	ScenarioSuccess = "success"
+	ScenarioFailure = "failure"
+	ScenarioDelayed = "delayed"
+	ScenarioManual  = "manual"
+)
+
+/* Payment statuses, indexed by Pesapal status_code */
+var statusDescriptions = map[int]string{0: "INVALID", 1: "COMPLETED", 2: "FAILED", 3: "REVERSED"}
+
+/* Date layout used by Pesapal for created_date */
+const createdDateLayout = "2006-01-02T15:04:05.000"
+
+type Options struct {
+	Scenario string        /* Initial scenario (default success) */
+	Delay    time.Duration /* How long delayed orders stay pending (default 10s) */
+	IPNDelay time.Duration /* Wait before firing an IPN, like the real service (default 1s) */
+	BaseURL  string        /* Public URL of the sandbox, used in redirect URLs */
+}
+
+type ipn struct {
+	ID   string `json:"ipn_id"`
+	URL  string `json:"url"`
+	Type string `json:"ipn_notification_type_description"`
+}
+
+type order struct {
+	TrackingID        string    `json:"order_tracking_id"`
+	MerchantReference string    `json:"merchant_reference"`
+	Amount            float64   `json:"amount"`
+	Currency          string    `json:"currency"`
+	Description       string    `json:"description"`
+	CallbackURL       string    `json:"callback_url"`
+	NotificationID    string    `json:"notification_id"`
+	AccountNumber     string    `json:"account_number"`
+	Recurring         bool      `json:"recurring"`
+	StatusCode        int       `json:"status_code"`
+	ConfirmationCode  string    `json:"confirmation_code"`
+	CreatedAt         time.Time `json:"created_at"`
+	Cancelled         bool      `json:"cancelled"`
+	Refunds           []float64 `json:"refunds"`
+}
+
+// Server is an in-memory Pesapal API. It implements http.Handler and is safe
+// for concurrent use.
+type Server struct {
+	opts   Options
+	mux    *http.ServeMux
+	client *http.Client
+
+	mu       sync.Mutex
+	scenario string
+	tokens   map[string]time.Time
+	ipns     map[string]ipn
+	orders   map[string]*order
+}
+
+// NewServer returns a sandbox configured with the given options.
+func NewServer(opts Options) *Server {
+	if opts.Scenario == "" {
+		opts.Scenario = ScenarioSuccess
+	}
+	if opts.Delay == 0 {
+		opts.Delay = 10 * time.Second
+	}
+	if opts.IPNDelay == 0 {
+		opts.IPNDelay = time.Second
+	}
+
+	s := &Server{
+		opts:     opts,
+		mux:      http.NewServeMux(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		scenario: opts.Scenario,
+		tokens:   make(map[string]time.Time),
+		ipns:     make(map[string]ipn),
+		orders:   make(map[string]*order),
+	}
+
+	/* Pesapal API */
+	s.mux.HandleFunc("POST /api/Auth/RequestToken", s.requestToken)
+	s.mux.HandleFunc("POST /api/URLSetup/RegisterIPN", s.authorized(s.registerIPN))
+	s.mux.HandleFunc("GET /api/URLSetup/GetIpnList", s.authorized(s.getIpnList))
+	s.mux.HandleFunc("POST /api/Transactions/SubmitOrderRequest", s.authorized(s.submitOrder))
+	s.mux.HandleFunc("GET /api/Transactions/GetTransactionStatus", s.authorized(s.getTransactionStatus))
+	s.mux.HandleFunc("POST /api/Transactions/RefundRequest", s.authorized(s.refundRequest))
+	s.mux.HandleFunc("POST /api/Transactions/CancelOrder", s.authorized(s.cancelOrder))
+
+	/* Hosted payment page */
+	s.mux.HandleFunc("GET /pay/{tracking_id}", s.pay)
+
+	/* Sandbox controls */
+	s.mux.HandleFunc("POST /sandbox/scenario", s.setScenario)
+	s.mux.HandleFunc("GET /sandbox/orders", s.listOrders)
+	s.mux.HandleFunc("POST /sandbox/orders/{tracking_id}/status", s.setOrderStatus)
+	s.mux.HandleFunc("POST /sandbox/orders/{tracking_id}/ipn", s.resendIPN)
+	s.mux.HandleFunc("POST /sandbox/orders/{tracking_id}/renew", s.renewOrder)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("sandbox: %s %s", r.Method, r.URL.RequestURI())
+	s.mux.ServeHTTP(w, r)
+}
+
+/* writeJSON sends v as a JSON response */
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+/* randomCode returns n random bytes as upper-case hex, like a confirmation code */
+func randomCode(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+/* authorized rejects requests without a bearer token issued by this sandbox */
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		expiry, ok := s.tokens[token]
+		s.mu.Unlock()
+
+		if !ok || time.Now().After(expiry) {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"error": map[string]string{"code": "invalid_token", "message": "Token is invalid or expired"},
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) requestToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ConsumerKey    string `json:"consumer_key"`
+		ConsumerSecret string `json:"consumer_secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ConsumerKey == "" || body.ConsumerSecret == "" {
+		/* Pesapal answers bad credentials with a 200 and no token */
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"token": nil, "status": "500",
+			"error": map[string]string{"code": "invalid_consumer_key_or_secret_provided"},
+		})
+		return
+	}
+
+	token := randomCode(32)
+	expiry := time.Now().Add(5 * time.Minute)
+
+	s.mu.Lock()
+	s.tokens[token] = expiry
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expiryDate": expiry.UTC().Format(time.RFC3339Nano),
+		"error":      nil,
+		"status":     "200",
+		"message":    "Request processed successfully",
+	})
+}
+
+func (s *Server) registerIPN(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URL  string `json:"url"`
+		Type string `json:"ipn_notification_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "url is required"})
+		return
+	}
+
+	registered := ipn{ID: uuid.NewString(), URL: body.URL, Type: body.Type}
+
+	s.mu.Lock()
+	s.ipns[registered.ID] = registered
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"url":                               registered.URL,
+		"created_date":                      time.Now().Format(createdDateLayout),
+		"ipn_id":                            registered.ID,
+		"ipn_notification_type_description": registered.Type,
+		"ipn_status":                        1,
+		"ipn_status_description":            "Active",
+		"status":                            "200",
+	})
+}
+
+func (s *Server) getIpnList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	list := make([]map[string]interface{}, 0, len(s.ipns))
+	for _, registered := range s.ipns {
+		list = append(list, map[string]interface{}{
+			"url":                               registered.URL,
+			"ipn_id":                            registered.ID,
+			"ipn_notification_type_description": registered.Type,
+			"ipn_status":                        1,
+			"ipn_status_description":            "Active",
+		})
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) submitOrder(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID                  string          `json:"id"`
+		Currency            string          `json:"currency"`
+		Amount              float64         `json:"amount"`
+		Description         string          `json:"description"`
+		CallbackURL         string          `json:"callback_url"`
+		NotificationID      string          `json:"notification_id"`
+		AccountNumber       string          `json:"account_number"`
+		SubscriptionDetails json.RawMessage `json:"subscription_details"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID == "" || body.Amount <= 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "400", "error": map[string]string{"code": "invalid_order", "message": "id and amount are required"},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	_, knownIPN := s.ipns[body.NotificationID]
+	s.mu.Unlock()
+	if !knownIPN {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "400", "error": map[string]string{"code": "invalid_ipn_id", "message": "notification_id is not registered"},
+		})
+		return
+	}
+
+	o := &order{
+		TrackingID:        uuid.NewString(),
+		MerchantReference: body.ID,
+		Amount:            body.Amount,
+		Currency:          body.Currency,
+		Description:       body.Description,
+		CallbackURL:       body.CallbackURL,
+		NotificationID:    body.NotificationID,
+		AccountNumber:     body.AccountNumber,
+		Recurring:         len(body.SubscriptionDetails) > 0 && string(body.SubscriptionDetails) != "null",
+		CreatedAt:         time.Now(),
+	}
+
+	s.mu.Lock()
+	s.orders[o.TrackingID] = o
+	scenario := s.scenario
+	s.mu.Unlock()
+
+	s.resolve(o, scenario)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_tracking_id":  o.TrackingID,
+		"merchant_reference": o.MerchantReference,
+		"redirect_url":       fmt.Sprintf("%s/pay/%s", s.opts.BaseURL, o.TrackingID),
+		"error":              nil,
+		"status":             "200",
+	})
+}
+
+/* resolve applies a scenario to a freshly submitted order */
+func (s *Server) resolve(o *order, scenario string) {
+	switch scenario {
+	case ScenarioSuccess:
+		s.setStatus(o.TrackingID, 1, "IPNCHANGE")
+	case ScenarioFailure:
+		s.setStatus(o.TrackingID, 2, "IPNCHANGE")
+	case ScenarioDelayed:
+		time.AfterFunc(s.opts.Delay, func() { s.setStatus(o.TrackingID, 1, "IPNCHANGE") })
+	}
+}
+
+/* setStatus changes an order's status and fires the matching IPN */
+func (s *Server) setStatus(trackingID string, statusCode int, notificationType string) bool {
+	s.mu.Lock()
+	o, ok := s.orders[trackingID]
+	if ok {
+		o.StatusCode = statusCode
+		if statusCode == 1 && o.ConfirmationCode == "" {
+			o.ConfirmationCode = randomCode(5)
+		}
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.fireIPN(trackingID, notificationType)
+	}
+	return ok
+}
+
+// fireIPN calls the order's IPN URL after Options.IPNDelay, the same way
+// Pesapal does: a GET (or POST) carrying the tracking ID, merchant reference
+// and notification type.
+func (s *Server) fireIPN(trackingID, notificationType string) {
+	s.mu.Lock()
+	o, ok := s.orders[trackingID]
+	var target ipn
+	if ok {
+		target, ok = s.ipns[o.NotificationID]
+	}
+	var merchantReference string
+	if ok {
+		merchantReference = o.MerchantReference
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	time.AfterFunc(s.opts.IPNDelay, func() {
+		params := url.Values{
+			"OrderTrackingId":        {trackingID},
+			"OrderMerchantReference": {merchantReference},
+			"OrderNotificationType":  {notificationType},
+		}
+
+		var resp *http.Response
+		var err error
+		if strings.EqualFold(target.Type, "POST") {
+			resp, err = s.client.Post(target.URL, "application/json", strings.NewReader(fmt.Sprintf(
+				`{"OrderTrackingId":%q,"OrderMerchantReference":%q,"OrderNotificationType":%q}`,
+				trackingID, merchantReference, notificationType)))
+		} else {
+			resp, err = s.client.Get(target.URL + "?" + params.Encode())
+		}
+		if err != nil {
+			log.Printf("sandbox: IPN to %s failed: %v", target.URL, err)
+			return
+		}
+		resp.Body.Close()
+		log.Printf("sandbox: IPN %s for %s answered %s", notificationType, trackingID, resp.Status)
+	})
+}
+
+func (s *Server) getTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	trackingID := r.URL.Query().Get("orderTrackingId")
+
+	s.mu.Lock()
+	o, ok := s.orders[trackingID]
+	var snapshot order
+	if ok {
+		snapshot = *o
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "500", "status_code": 0, "payment_status_description": "INVALID",
+			"error": map[string]string{"code": "payment_details_not_found", "message": "Pending Payment"},
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"payment_method":             "MpesaKE",
+		"amount":                     snapshot.Amount,
+		"created_date":               snapshot.CreatedAt.Format(createdDateLayout),
+		"confirmation_code":          snapshot.ConfirmationCode,
+		"payment_status_description": statusDescriptions[snapshot.StatusCode],
+		"description":                snapshot.Description,
+		"message":                    "Request processed successfully",
+		"payment_account":            "2547XXXXX678",
+		"call_back_url":              snapshot.CallbackURL,
+		"status_code":                snapshot.StatusCode,
+		"merchant_reference":         snapshot.MerchantReference,
+		"currency":                   snapshot.Currency,
+		"error":                      map[string]interface{}{"error_type": nil, "code": nil, "message": nil},
+		"status":                     "200",
+	}
+	if snapshot.Recurring {
+		response["subscription_transaction_info"] = map[string]interface{}{
+			"account_reference": snapshot.AccountNumber,
+			"amount":            snapshot.Amount,
+			"first_name":        "Sandbox",
+			"last_name":         "Customer",
+			"correlation_id":    time.Now().Unix(),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) refundRequest(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ConfirmationCode string  `json:"confirmation_code"`
+		Amount           float64 `json:"amount,string"`
+		Username         string  `json:"username"`
+		Remarks          string  `json:"remarks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "500", "message": "Invalid refund request"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, o := range s.orders {
+		if o.ConfirmationCode != body.ConfirmationCode || o.StatusCode != 1 {
+			continue
+		}
+
+		refunded := 0.0
+		for _, amount := range o.Refunds {
+			refunded += amount
+		}
+		if body.Amount <= 0 || refunded+body.Amount > o.Amount {
+			writeJSON(w, http.StatusOK, map[string]string{"status": "500", "message": "Refund amount exceeds the amount paid"})
+			return
+		}
+
+		o.Refunds = append(o.Refunds, body.Amount)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "200", "message": "Refund request successfully"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "500", "message": "Payment not found"})
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		OrderTrackingID string `json:"order_tracking_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[body.OrderTrackingID]
+	if !ok || o.StatusCode == 1 || o.Cancelled {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "500", "message": "Order cannot be cancelled"})
+		return
+	}
+
+	/* A cancelled order can no longer be paid; Pesapal reports it as failed */
+	o.Cancelled = true
+	o.StatusCode = 2
+	writeJSON(w, http.StatusOK, map[string]string{"status": "200", "message": "Order successfully cancelled"})
+}
+
+// pay simulates the customer completing checkout on the hosted payment page.
+// Orders that are still pending are completed (or failed under the failure
+// scenario) and the customer is redirected to the order's callback URL.
+func (s *Server) pay(w http.ResponseWriter, r *http.Request) {
+	trackingID := r.PathValue("tracking_id")
+
+	s.mu.Lock()
+	o, ok := s.orders[trackingID]
+	var pending bool
+	var callback, merchantReference string
+	if ok {
+		pending = o.StatusCode == 0 && !o.Cancelled
+		callback = o.CallbackURL
+		merchantReference = o.MerchantReference
+	}
+	scenario := s.scenario
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if pending {
+		statusCode := 1
+		if scenario == ScenarioFailure {
+			statusCode = 2
+		}
+		s.setStatus(trackingID, statusCode, "IPNCHANGE")
+	}
+
+	if callback == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"message": "Payment processed"})
+		return
+	}
+
+	params := url.Values{
+		"OrderTrackingId":        {trackingID},
+		"OrderMerchantReference": {merchantReference},
+		"OrderNotificationType":  {"CALLBACKURL"},
+	}
+	http.Redirect(w, r, callback+"?"+params.Encode(), http.StatusFound)
+}
+
+func (s *Server) setScenario(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Scenario string `json:"scenario"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch body.Scenario {
+	case ScenarioSuccess, ScenarioFailure, ScenarioDelayed, ScenarioManual:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown scenario"})
+		return
+	}
+
+	s.mu.Lock()
+	s.scenario = body.Scenario
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"scenario": body.Scenario})
+}
+
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	orders := make([]order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, *o)
+	}
+	scenario := s.scenario
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"scenario": scenario, "orders": orders})
+}
+
+func (s *Server) setOrderStatus(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Status string `json:"status"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	statusCode := -1
+	for code, description := range statusDescriptions {
+		if strings.EqualFold(description, body.Status) {
+			statusCode = code
+		}
+	}
+	if statusCode < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be one of INVALID, COMPLETED, FAILED, REVERSED"})
+		return
+	}
+
+	if !s.setStatus(r.PathValue("tracking_id"), statusCode, "IPNCHANGE") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "order not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "status updated, IPN scheduled"})
+}
+
+func (s *Server) resendIPN(w http.ResponseWriter, r *http.Request) {
+	trackingID := r.PathValue("tracking_id")
+
+	s.mu.Lock()
+	_, ok := s.orders[trackingID]
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "order not found"})
+		return
+	}
+
+	s.fireIPN(trackingID, "IPNCHANGE")
+	writeJSON(w, http.StatusOK, map[string]string{"message": "IPN scheduled"})
+}
+
+// renewOrder charges a recurring order again. The charge gets its own
+// tracking ID under the original merchant reference, is resolved by the
+// current scenario (failure fails it, anything else completes it) and is
+// announced with a RECURRING IPN.
+func (s *Server) renewOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	parent, ok := s.orders[r.PathValue("tracking_id")]
+	if !ok || !parent.Recurring {
+		s.mu.Unlock()
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "recurring order not found"})
+		return
+	}
+
+	renewal := *parent
+	renewal.TrackingID = uuid.NewString()
+	renewal.ConfirmationCode = ""
+	renewal.Refunds = nil
+	renewal.CreatedAt = time.Now()
+	renewal.StatusCode = 1
+	if s.scenario == ScenarioFailure {
+		renewal.StatusCode = 2
+	} else {
+		renewal.ConfirmationCode = randomCode(5)
+	}
+	s.orders[renewal.TrackingID] = &renewal
+	s.mu.Unlock()
+
+	s.fireIPN(renewal.TrackingID, "RECURRING")
+	writeJSON(w, http.StatusOK, map[string]string{"order_tracking_id": renewal.TrackingID, "message": "renewal charged, IPN scheduled"})
+}
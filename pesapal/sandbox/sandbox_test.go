@@ -0,0 +1,282 @@
+package sandbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bot-on-tapwater/cbcexams-backend/pesapal"
+)
+
+/* noRedirect opens the hosted payment page without following the redirect to the callback URL */
+var noRedirect = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+/* notification is an IPN call received by the test's IPN endpoint */
+type notification struct {
+	TrackingID        string
+	MerchantReference string
+	Type              string
+}
+
+// newTestClient starts a sandbox with the given scenario and an IPN endpoint,
+// and returns a pesapal.Client pointed at the sandbox, the sandbox's test
+// server and the channel the IPN endpoint delivers notifications to.
+func newTestClient(t *testing.T, scenario string) (*pesapal.Client, *httptest.Server, <-chan notification) {
+	t.Helper()
+
+	notifications := make(chan notification, 10)
+	ipnServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		notifications <- notification{
+			TrackingID:        query.Get("OrderTrackingId"),
+			MerchantReference: query.Get("OrderMerchantReference"),
+			Type:              query.Get("OrderNotificationType"),
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ipnServer.Close)
+
+	sandbox := NewServer(Options{Scenario: scenario, IPNDelay: time.Millisecond, Delay: 20 * time.Millisecond})
+	sandboxServer := httptest.NewServer(sandbox)
+	t.Cleanup(sandboxServer.Close)
+	sandbox.opts.BaseURL = sandboxServer.URL
+
+	client := pesapal.NewClient(&pesapal.Config{
+		ConsumerKey:    "key",
+		ConsumerSecret: "secret",
+		BaseURL:        sandboxServer.URL,
+		CallbackURL:    "https://example.com/payment/callback",
+		IPNURL:         ipnServer.URL,
+	})
+	return client, sandboxServer, notifications
+}
+
+/* waitForIPN returns the next notification, failing the test if none arrives */
+func waitForIPN(t *testing.T, notifications <-chan notification) notification {
+	t.Helper()
+	select {
+	case n := <-notifications:
+		return n
+	case <-time.After(2 * time.Second):
+		t.Fatal("no IPN received")
+		return notification{}
+	}
+}
+
+/* submitOrder places a 500 KES order, recurring if accountNumber is set */
+func submitOrder(t *testing.T, client *pesapal.Client, merchantReference, accountNumber string) *pesapal.OrderResponse {
+	t.Helper()
+	order := pesapal.OrderRequest{
+		ID:          merchantReference,
+		Currency:    "KES",
+		Amount:      500,
+		Description: "Monthly Auto-Renewing Access",
+	}
+	if accountNumber != "" {
+		order.AccountNumber = accountNumber
+		order.SubscriptionDetails = &pesapal.SubscriptionDetails{
+			StartDate: "17-10-2026",
+			EndDate:   "17-10-2027",
+			Frequency: pesapal.FrequencyMonthly,
+		}
+	}
+
+	resp, err := client.SubmitOrder(order)
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+	if resp.OrderTrackingID == "" || resp.RedirectURL == "" {
+		t.Fatalf("SubmitOrder returned %+v", resp)
+	}
+	return resp
+}
+
+/* transactionStatus fetches a status and fails the test on error */
+func transactionStatus(t *testing.T, client *pesapal.Client, trackingID string) *pesapal.TransactionStatusResponse {
+	t.Helper()
+	status, err := client.GetTransactionStatus(trackingID)
+	if err != nil {
+		t.Fatalf("GetTransactionStatus: %v", err)
+	}
+	return status
+}
+
+func TestSuccessfulPayment(t *testing.T) {
+	client, _, notifications := newTestClient(t, ScenarioSuccess)
+
+	order := submitOrder(t, client, "order-success", "")
+
+	n := waitForIPN(t, notifications)
+	if n.Type != "IPNCHANGE" || n.TrackingID != order.OrderTrackingID || n.MerchantReference != "order-success" {
+		t.Fatalf("unexpected IPN %+v", n)
+	}
+
+	status := transactionStatus(t, client, n.TrackingID)
+	if status.StatusCode != pesapal.StatusCodeCompleted {
+		t.Errorf("status_code = %d, want %d", status.StatusCode, pesapal.StatusCodeCompleted)
+	}
+	if status.MerchantReference != "order-success" || status.Amount != 500 || status.Currency != "KES" {
+		t.Errorf("status does not describe the order: %+v", status)
+	}
+	if status.ConfirmationCode == "" {
+		t.Error("completed payment has no confirmation code")
+	}
+	if status.SubscriptionTransactionInfo != nil {
+		t.Error("one-off payment reports subscription info")
+	}
+}
+
+func TestFailedPayment(t *testing.T) {
+	client, _, notifications := newTestClient(t, ScenarioFailure)
+
+	submitOrder(t, client, "order-failure", "")
+
+	status := transactionStatus(t, client, waitForIPN(t, notifications).TrackingID)
+	if status.StatusCode != pesapal.StatusCodeFailed {
+		t.Errorf("status_code = %d, want %d", status.StatusCode, pesapal.StatusCodeFailed)
+	}
+	if status.ConfirmationCode != "" {
+		t.Errorf("failed payment has confirmation code %q", status.ConfirmationCode)
+	}
+}
+
+func TestDelayedPayment(t *testing.T) {
+	client, _, notifications := newTestClient(t, ScenarioDelayed)
+
+	order := submitOrder(t, client, "order-delayed", "")
+
+	if status := transactionStatus(t, client, order.OrderTrackingID); status.StatusCode != 0 {
+		t.Errorf("status_code before the delay = %d, want 0", status.StatusCode)
+	}
+
+	status := transactionStatus(t, client, waitForIPN(t, notifications).TrackingID)
+	if status.StatusCode != pesapal.StatusCodeCompleted {
+		t.Errorf("status_code after the delay = %d, want %d", status.StatusCode, pesapal.StatusCodeCompleted)
+	}
+}
+
+func TestManualPaymentThroughRedirect(t *testing.T) {
+	client, _, notifications := newTestClient(t, ScenarioManual)
+
+	order := submitOrder(t, client, "order-manual", "")
+
+	resp, err := noRedirect.Get(order.RedirectURL)
+	if err != nil {
+		t.Fatalf("opening the redirect URL: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), "https://example.com/payment/callback?") {
+		t.Errorf("redirect = %s to %q", resp.Status, resp.Header.Get("Location"))
+	}
+
+	status := transactionStatus(t, client, waitForIPN(t, notifications).TrackingID)
+	if status.StatusCode != pesapal.StatusCodeCompleted {
+		t.Errorf("status_code = %d, want %d", status.StatusCode, pesapal.StatusCodeCompleted)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	client, _, _ := newTestClient(t, ScenarioManual)
+
+	order := submitOrder(t, client, "order-cancel", "")
+
+	cancelResp, err := client.CancelOrder(order.OrderTrackingID)
+	if err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	if !cancelResp.Succeeded() {
+		t.Fatalf("CancelOrder refused: %+v", cancelResp)
+	}
+
+	status := transactionStatus(t, client, order.OrderTrackingID)
+	if status.StatusCode != pesapal.StatusCodeFailed {
+		t.Errorf("status_code after cancelling = %d, want %d", status.StatusCode, pesapal.StatusCodeFailed)
+	}
+
+	/* A cancelled order can neither be cancelled again nor paid */
+	if again, err := client.CancelOrder(order.OrderTrackingID); err != nil || again.Succeeded() {
+		t.Errorf("second CancelOrder = %+v, %v; want a refusal", again, err)
+	}
+	resp, err := noRedirect.Get(order.RedirectURL)
+	if err != nil {
+		t.Fatalf("opening the redirect URL: %v", err)
+	}
+	resp.Body.Close()
+	if status := transactionStatus(t, client, order.OrderTrackingID); status.StatusCode != pesapal.StatusCodeFailed {
+		t.Errorf("status_code after paying a cancelled order = %d, want %d", status.StatusCode, pesapal.StatusCodeFailed)
+	}
+}
+
+func TestCancelCompletedOrder(t *testing.T) {
+	client, _, notifications := newTestClient(t, ScenarioSuccess)
+
+	order := submitOrder(t, client, "order-paid", "")
+	waitForIPN(t, notifications)
+
+	cancelResp, err := client.CancelOrder(order.OrderTrackingID)
+	if err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	if cancelResp.Succeeded() {
+		t.Error("a completed order was cancelled")
+	}
+	if status := transactionStatus(t, client, order.OrderTrackingID); status.StatusCode != pesapal.StatusCodeCompleted {
+		t.Errorf("status_code = %d, want %d", status.StatusCode, pesapal.StatusCodeCompleted)
+	}
+}
+
+func TestRecurringRenewal(t *testing.T) {
+	client, sandboxServer, notifications := newTestClient(t, ScenarioSuccess)
+
+	order := submitOrder(t, client, "order-recurring", "account-1")
+	waitForIPN(t, notifications)
+
+	resp, err := http.Post(sandboxServer.URL+"/sandbox/orders/"+order.OrderTrackingID+"/renew", "application/json", nil)
+	if err != nil {
+		t.Fatalf("renewing: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("renew answered %s", resp.Status)
+	}
+
+	n := waitForIPN(t, notifications)
+	if n.Type != "RECURRING" || n.MerchantReference != "order-recurring" || n.TrackingID == order.OrderTrackingID {
+		t.Fatalf("unexpected renewal IPN %+v", n)
+	}
+
+	status := transactionStatus(t, client, n.TrackingID)
+	if status.StatusCode != pesapal.StatusCodeCompleted || status.MerchantReference != "order-recurring" || status.Amount != 500 {
+		t.Errorf("renewal status does not describe the subscription: %+v", status)
+	}
+	if info := status.SubscriptionTransactionInfo; info == nil || info.AccountReference != "account-1" {
+		t.Errorf("renewal subscription info = %+v, want account reference account-1", info)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	client, _, notifications := newTestClient(t, ScenarioSuccess)
+
+	submitOrder(t, client, "order-refund", "")
+	status := transactionStatus(t, client, waitForIPN(t, notifications).TrackingID)
+
+	for _, tc := range []struct {
+		amount float64
+		ok     bool
+	}{{300, true}, {300, false}, {200, true}} {
+		resp, err := client.RequestRefund(pesapal.RefundRequest{
+			ConfirmationCode: status.ConfirmationCode,
+			Amount:           tc.amount,
+			Username:         "admin@example.com",
+			Remarks:          "test",
+		})
+		if err != nil {
+			t.Fatalf("RequestRefund: %v", err)
+		}
+		if resp.Succeeded() != tc.ok {
+			t.Errorf("refund of %.0f: succeeded = %v, want %v", tc.amount, resp.Succeeded(), tc.ok)
+		}
+	}
+}
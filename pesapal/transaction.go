@@ -7,14 +7,44 @@ import (
 	"time"
 )
 
+/* Payment status codes returned by GetTransactionStatus */
+const (
+	StatusCodeInvalid   = 0
+	StatusCodeCompleted = 1
+	StatusCodeFailed    = 2
+	StatusCodeReversed  = 3
+)
+
 type TransactionStatusResponse struct {
-	PaymentMethod            string     `json:"payment_method"`
-	Amount                   float64    `json:"amount"`
-	CreatedDate              CustomTime `json:"created_date"`
-	ConfirmationCode         string     `json:"confirmation_code"`
-	PaymentStatusDescription string     `json:"payment_status_description"`
-	Description              string     `json:"description"`
-	Message                  string     `json:"message"`
+	PaymentMethod            string            `json:"payment_method"`
+	Amount                   float64           `json:"amount"`
+	CreatedDate              CustomTime        `json:"created_date"`
+	ConfirmationCode         string            `json:"confirmation_code"`
+	PaymentStatusDescription string            `json:"payment_status_description"`
+	Description              string            `json:"description"`
+	Message                  string            `json:"message"`
+	PaymentAccount           string            `json:"payment_account"`
+	StatusCode               int               `json:"status_code"`
+	MerchantReference        string            `json:"merchant_reference"`
+	Currency                 string            `json:"currency"`
+	Error                    *TransactionError `json:"error"`
+
+	SubscriptionTransactionInfo *SubscriptionTransactionInfo `json:"subscription_transaction_info"`
+}
+
+/* Present on recurring payments made under subscription_details */
+type SubscriptionTransactionInfo struct {
+	AccountReference string  `json:"account_reference"`
+	Amount           float64 `json:"amount"`
+	FirstName        string  `json:"first_name"`
+	LastName         string  `json:"last_name"`
+	CorrelationID    int64   `json:"correlation_id"`
+}
+
+type TransactionError struct {
+	ErrorType string `json:"error_type"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
 }
 
 type CustomTime struct {
@@ -23,14 +53,22 @@ type CustomTime struct {
 
 /* UnmarshalJSON parses the custom time format without a timezone offset. */
 func (ct *CustomTime) UnmarshalJSON(b []byte) error {
+	// Pesapal sends null for orders that were never paid
+	if string(b) == "null" || string(b) == `""` {
+		return nil
+	}
+
 	// Remove quotes from the JSON string
 	s := string(b)
 	s = s[1 : len(s)-1]
 
-	// Parse the time without a timezone offset
+	// Parse the time without a timezone offset, falling back to RFC 3339
 	parsedTime, err := time.Parse("2006-01-02T15:04:05.000", s)
 	if err != nil {
-		return err
+		parsedTime, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
 	}
 
 	ct.Time = parsedTime
@@ -38,7 +76,7 @@ func (ct *CustomTime) UnmarshalJSON(b []byte) error {
 }
 
 func (c *Config) GetTransactionStatus(authToken, orderTrackingID string) (*TransactionStatusResponse, error) {
-	client := &http.Client{}
+	client := c.httpClient()
 	url := fmt.Sprintf("%s/api/Transactions/GetTransactionStatus?orderTrackingId=%s", c.BaseURL, orderTrackingID)
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -3,12 +3,15 @@ package routes
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
 	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
+	"github.com/bot-on-tapwater/cbcexams-backend/utils"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 func AuthRoutes(r *gin.Engine, db *gorm.DB) {
-	authController := controllers.AuthController{DB: db}
+	authController := controllers.AuthController{DB: db, SMS: utils.NewSMSSenderFromEnv()}
+	googleController := controllers.NewGoogleAuthController(db)
 
 	auth := r.Group("/v1/auth")
 	{
@@ -16,12 +19,36 @@ func AuthRoutes(r *gin.Engine, db *gorm.DB) {
 		auth.POST("/login", authController.Login)
 		auth.POST("/forgot-password", authController.ForgotPassword)
 		auth.POST("/reset-password", authController.ResetPassword)
+		auth.POST("/verify-email", authController.VerifyEmail)
+		auth.POST("/resend-verification", authController.ResendVerification)
+		auth.POST("/refresh", authController.Refresh)
+		auth.POST("/otp/request", authController.RequestOTP)
+		auth.POST("/otp/verify", authController.VerifyOTP)
+		auth.POST("/2fa/verify", authController.VerifyTwoFactor)
+		auth.GET("/google", googleController.Start)
+		auth.GET("/google/callback", googleController.Callback)
 	}
 
 	protected := r.Group("/v1/auth")
 	protected.Use(middleware.JWTAuth())
 	{
 		protected.POST("/logout", authController.Logout)
+		protected.POST("/logout-all", authController.LogoutAll)
+		protected.GET("/sessions", authController.GetSessions)
+		protected.DELETE("/sessions/:id", authController.RevokeSession)
+		protected.GET("/identities", googleController.GetIdentities)
+		protected.DELETE("/identities/:provider", googleController.UnlinkIdentity)
+		protected.GET("/2fa", authController.GetTwoFactorStatus)
+		protected.POST("/2fa/recovery-codes", authController.RegenerateRecoveryCodes)
+		protected.POST("/2fa/disable", authController.DisableTwoFactor)
 		protected.GET("/check", authController.CheckAuth)
 	}
+
+	/* Two-factor enrollment is offered to accounts that can see other users' data */
+	twoFactor := r.Group("/v1/auth/2fa")
+	twoFactor.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin, models.RoleSchool))
+	{
+		twoFactor.POST("/setup", authController.SetupTwoFactor)
+		twoFactor.POST("/confirm", authController.ConfirmTwoFactor)
+	}
 }
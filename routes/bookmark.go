@@ -11,7 +11,7 @@ func BookmarkRoutes(r *gin.Engine, db *gorm.DB) {
 	bookmarkCtrl := controllers.BookmarkController{DB: db}
 
 	protected := r.Group("/v1/api/bookmarks")
-	protected.Use(middleware.JWTAuth())
+	protected.Use(middleware.JWTAuth(), middleware.SubscriptionStatus(db))
 	{
 		protected.POST("", bookmarkCtrl.CreateBookmark)
 		protected.DELETE("/:resource_id", bookmarkCtrl.DeleteBookmark)
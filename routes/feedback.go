@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
+	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"gorm.io/gorm"
 
 	"github.com/gin-gonic/gin"
@@ -13,7 +15,12 @@ func FeedbackRoutes(r *gin.Engine, db *gorm.DB) {
 
 	{
 		v1.POST("", feedbackCtrl.SubmitFeedback)
-		v1.GET("", feedbackCtrl.GetFeedback)
+	}
 
+	/* Feedback contains contact details, so only admins may read it */
+	admin := r.Group("v1/api/feedback")
+	admin.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("", feedbackCtrl.GetFeedback)
 	}
 }
@@ -2,16 +2,39 @@ package routes
 
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
+	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-func PaymentRoutes(r *gin.Engine) {
-	paymentCtrl := controllers.NewPaymentController()
+func PaymentRoutes(r *gin.Engine, db *gorm.DB) {
+	paymentCtrl := controllers.NewPaymentController(db)
 
-	payment := r.Group("v1/api/payments")
+	/* Pesapal calls the IPN URL without a token; the catalog is public */
+	public := r.Group("v1/api/payments")
 	{
-		payment.POST("/initiate", paymentCtrl.InitiatePayment)
-		payment.GET("/status/:order_id", paymentCtrl.CheckPaymentStatus)
-		payment.GET("/ipn", paymentCtrl.HandleIPN)
+		public.GET("/products", paymentCtrl.GetProducts)
+		public.GET("/ipn", paymentCtrl.HandleIPN)
+	}
+
+	protected := r.Group("v1/api/payments")
+	protected.Use(middleware.JWTAuth())
+	{
+		protected.POST("/initiate", paymentCtrl.InitiatePayment)
+		protected.GET("/status/:order_id", paymentCtrl.CheckPaymentStatus)
+		protected.GET("/me", paymentCtrl.GetMyPayments)
+		protected.GET("/receipts", paymentCtrl.GetReceipts)
+		protected.GET("/receipts/:id", paymentCtrl.GetReceipt)
+	}
+
+	admin := r.Group("v1/api/payments")
+	admin.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.POST("/orders/:id/refund", paymentCtrl.RefundOrder)
+		admin.POST("/orders/:id/cancel", paymentCtrl.CancelOrder)
+		admin.GET("/reports/revenue", paymentCtrl.GetRevenueByPeriod)
+		admin.GET("/reports/products", paymentCtrl.GetRevenueByProduct)
+		admin.GET("/reports/payment-methods", paymentCtrl.GetRevenueByPaymentMethod)
 	}
 }
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
+	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -10,8 +12,21 @@ func ResourceRoutes(r *gin.Engine, db *gorm.DB) {
 	resourceCtrl := controllers.ResourceController{DB: db}
 
 	resources := r.Group("v1/api/resources")
+	resources.Use(middleware.OptionalJWTAuth(), middleware.SubscriptionStatus(db))
 	{
 		resources.GET("", resourceCtrl.GetResources)
 		resources.GET("/parent-directories", resourceCtrl.GetUniqeParentDirectories)
+		resources.GET("/:id", resourceCtrl.GetResource)
+	}
+
+	/* Resources used to be loaded with psql dumps; admins now manage them here */
+	admin := r.Group("v1/api/resources")
+	admin.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.POST("", resourceCtrl.CreateResource)
+		admin.POST("/import", resourceCtrl.ImportResources)
+		admin.PATCH("/:id", resourceCtrl.UpdateResource)
+		admin.PATCH("/:id/categories", resourceCtrl.RecategorizeResource)
+		admin.DELETE("/:id", resourceCtrl.DeleteResource)
 	}
 }
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
+	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func SubscriptionRoutes(r *gin.Engine, db *gorm.DB) {
+	subscriptionCtrl := controllers.SubscriptionController{DB: db}
+
+	v1 := r.Group("v1/api/subscriptions")
+	{
+		v1.GET("/plans", subscriptionCtrl.GetPlans)
+	}
+
+	protected := r.Group("v1/api/subscriptions")
+	protected.Use(middleware.JWTAuth())
+	{
+		protected.GET("/me", subscriptionCtrl.GetMySubscription)
+	}
+}
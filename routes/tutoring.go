@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
+	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"gorm.io/gorm"
 
 	"github.com/gin-gonic/gin"
@@ -14,10 +16,22 @@ func TutoringRoutes(r *gin.Engine, db *gorm.DB) {
 	{
 		/* Tutor Requests (Students/Parents) */
 		v1.POST("/requests", tutoringCtrl.CreateTutorRequest)
-		v1.GET("/requests", tutoringCtrl.GetTutorRequests)
 
 		/* Tutor Applications (Tutors) */
 		v1.POST("/applications", tutoringCtrl.CreateTutorApplication)
-		v1.GET("/applications", tutoringCtrl.GetTutorApplications)
+	}
+
+	/* Requests carry students' contact details; only tutors approved by an admin may read them */
+	tutors := r.Group("v1/api/tutoring")
+	tutors.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleTutor, models.RoleAdmin))
+	{
+		tutors.GET("/requests", tutoringCtrl.GetTutorRequests)
+	}
+
+	/* Applications carry resumes and are reviewed by admins only */
+	admin := r.Group("v1/api/tutoring")
+	admin.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("/applications", tutoringCtrl.GetTutorApplications)
 	}
 }
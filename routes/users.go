@@ -3,6 +3,7 @@ package routes
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
 	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -14,7 +15,18 @@ func UsersRoutes(r *gin.Engine, db *gorm.DB) {
 	protected.Use(middleware.JWTAuth())
 	{
 		protected.GET("/profile", usersController.Profile)
-		protected.GET("", usersController.GetUsers)
 		protected.PATCH("/update-profile", usersController.UpdateProfile)
+		protected.PATCH("/change-password", usersController.ChangePassword)
+		protected.GET("/me/export", usersController.ExportMyData)
+		protected.DELETE("/me", usersController.DeleteMyAccount)
+		protected.GET("/billing-profile", usersController.GetBillingProfile)
+		protected.PUT("/billing-profile", usersController.UpdateBillingProfile)
+	}
+
+	admin := r.Group("/v1/api/users")
+	admin.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("", usersController.GetUsers)
+		admin.PATCH("/:id/role", usersController.UpdateUserRole)
 	}
 }
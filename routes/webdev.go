@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/bot-on-tapwater/cbcexams-backend/controllers"
+	"github.com/bot-on-tapwater/cbcexams-backend/middleware"
+	"github.com/bot-on-tapwater/cbcexams-backend/models"
 	"gorm.io/gorm"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +15,11 @@ func WebDevRoutes(r *gin.Engine, db *gorm.DB) {
 
 	{
 		v1.POST("/requests", webdevCtrl.CreateRequest)
-		v1.GET("/requests", webdevCtrl.GetRequests)
+	}
+
+	admin := r.Group("v1/api/webdev")
+	admin.Use(middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.GET("/requests", webdevCtrl.GetRequests)
 	}
 }
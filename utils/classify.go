@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ClassifierVersion changes whenever ClassifyResource's rules do, so stored
+classifications made by an older version are recomputed.
+*/
+const ClassifierVersion = 1
+
+/* ResourceClassification is what ClassifyResource can tell about a resource; unknown fields are empty or zero */
+type ResourceClassification struct {
+	Level   string /* "Grade 4", "Form 2", "PP1", "Playgroup" */
+	Subject string /* "Mathematics", "Christian Religious Education" */
+	Type    string /* "Marking Scheme", "End-Term", "Notes" */
+	Term    int    /* 1, 2 or 3 */
+	Year    int
+}
+
+/* Labels lists the classification's non-empty values */
+func (rc ResourceClassification) Labels() []string {
+	var labels []string
+	for _, label := range []string{rc.Level, rc.Subject, rc.Type} {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if rc.Term != 0 {
+		labels = append(labels, "Term "+strconv.Itoa(rc.Term))
+	}
+	if rc.Year != 0 {
+		labels = append(labels, strconv.Itoa(rc.Year))
+	}
+	return labels
+}
+
+/* classifierRule maps a pattern found in a name or directory to a canonical label */
+type classifierRule struct {
+	label   string
+	pattern *regexp.Regexp
+}
+
+/* rules builds word-bounded patterns for normalised text; the first matching rule wins, so specific labels come first */
+func rules(pairs ...string) []classifierRule {
+	list := make([]classifierRule, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		list = append(list, classifierRule{label: pairs[i], pattern: regexp.MustCompile(`\b(?:` + pairs[i+1] + `)\b`)})
+	}
+	return list
+}
+
+var (
+	gradePattern      = regexp.MustCompile(`\b(?:grade|grd|gr|g|class|std|standard)\s?(\d{1,2})\b`)
+	formPattern       = regexp.MustCompile(`\b(?:form|frm|f)\s?([1-4])\b`)
+	prePrimaryPattern = regexp.MustCompile(`\b(?:pp|pre\s?primary)\s?([12])\b`)
+	playgroupPattern  = regexp.MustCompile(`\bplay\s?group\b`)
+	termPattern       = regexp.MustCompile(`\b(?:term|t)\s?([123])\b`)
+	yearPattern       = regexp.MustCompile(`\b(19[89]\d|20\d\d)\b`)
+
+	separatorPattern = regexp.MustCompile(`[^a-z0-9&]+`)
+)
+
+var subjectRules = rules(
+	"Christian Religious Education", `cre|christian religious(?: education)?|c r e`,
+	"Islamic Religious Education", `ire|islamic religious(?: education)?`,
+	"Hindu Religious Education", `hre|hindu religious(?: education)?`,
+	"Integrated Science", `integrated science|int sci`,
+	"Pre-Technical Studies", `pre ?technical(?: studies)?|pre tech|pts`,
+	"Science & Technology", `science (?:&|and) technology|sci ?tech`,
+	"Business Studies", `business(?: studies)?|bst`,
+	"Computer Studies", `computer(?: studies| science)?|comp`,
+	"History & Government", `history (?:&|and) government|hist(?:ory)? (?:&|and )?gov(?:t|ernment)?`,
+	"Home Science", `home science|homescience|hsc`,
+	"Health Education", `health education`,
+	"Hygiene & Nutrition", `hygiene (?:&|and) nutrition`,
+	"Environmental Activities", `environmental(?: activities)?`,
+	"Language Activities", `language activities|lang act`,
+	"Mathematical Activities", `mathematical activities`,
+	"Psychomotor & Creative Activities", `psychomotor(?: (?:&|and) creative activities)?`,
+	"Creative Arts", `creative arts?|art (?:&|and) craft`,
+	"Art & Design", `art (?:&|and) design`,
+	"Social Studies", `social studies|sst`,
+	"Life Skills", `life skills`,
+	"Physical Education", `physical education|pe`,
+	"Kenyan Sign Language", `sign language|ksl`,
+	"Mathematics", `mathematics|maths?|hisabati`,
+	"English", `english|eng`,
+	"Kiswahili", `kiswahili|kisw|kis`,
+	"Biology", `biology|bio`,
+	"Chemistry", `chemistry|chem`,
+	"Physics", `physics|phy`,
+	"Geography", `geography|geo`,
+	"History", `history|hist`,
+	"Agriculture", `agriculture|agriculuture|agric?|agri`,
+	"Science", `science|sci`,
+	"Music", `music`,
+	"French", `french`,
+	"German", `german`,
+	"Arabic", `arabic`,
+)
+
+var typeRules = rules(
+	"Marking Scheme", `marking schemes?|ms|answers?`,
+	"Schemes of Work", `schemes? of work|sow`,
+	"Lesson Plan", `lesson ?plans?`,
+	"Records of Work", `records? of work`,
+	"Assessment Rubric", `assessment rubrics?|rubrics?`,
+	"Assessment Book", `assessment books?`,
+	"Revision Booklet", `revision(?: booklets?| books?)?`,
+	"Syllabus", `syllabus|curriculum design`,
+	"Design Material", `design materials?`,
+	"KCSE", `kcse`,
+	"KPSEA", `kpsea`,
+	"KJSEA", `kjsea`,
+	"Mock", `mocks?`,
+	"Opener", `opener|opening`,
+	"Mid-Term", `mid ?terms?`,
+	"End-Term", `end ?(?:of ?)?terms?`,
+	"Notes", `notes`,
+	"Assignment", `assignments?|holiday work`,
+	"Guide", `guides?|teachers? guide`,
+	"Exam", `exams?|examinations?|tests?|cats?|papers?|questions?|qp`,
+)
+
+/* normalizeForClassifier lowercases text and turns separators into single spaces */
+func normalizeForClassifier(text string) string {
+	text = separatorPattern.ReplaceAllString(strings.ToLower(text), " ")
+	return " " + strings.TrimSpace(text) + " "
+}
+
+/* classifySources returns the texts to classify, most specific first: the name, then directories from the deepest up */
+func classifySources(name, parentDirectory string) []string {
+	sources := []string{normalizeForClassifier(name)}
+	segments := strings.Split(parentDirectory, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segment := strings.TrimSpace(segments[i]); segment != "" {
+			sources = append(sources, normalizeForClassifier(segment))
+		}
+	}
+	return sources
+}
+
+// ClassifyResource derives a resource's level, subject, type, term and year
+// from its file name and parent directory. The name is checked first, then
+// the directories from the deepest up, so the most specific mention wins.
+//
+// Parameters:
+//   - name: The file name, e.g. "Grade_4_Maths_End_Term_2_2024.pdf".
+//   - parentDirectory: The directory path the file was crawled from.
+//
+// Returns:
+//   - ResourceClassification: The labels found; missing ones are left empty.
+func ClassifyResource(name, parentDirectory string) ResourceClassification {
+	var result ResourceClassification
+	maxYear := time.Now().Year() + 1
+
+	for _, text := range classifySources(name, parentDirectory) {
+		if result.Level == "" {
+			result.Level = classifyLevel(text)
+		}
+		if result.Subject == "" {
+			result.Subject = firstRule(subjectRules, text)
+		}
+		if result.Type == "" {
+			result.Type = firstRule(typeRules, text)
+		}
+		if result.Term == 0 {
+			if m := termPattern.FindStringSubmatch(text); m != nil {
+				result.Term, _ = strconv.Atoi(m[1])
+			}
+		}
+		if result.Year == 0 {
+			for _, m := range yearPattern.FindAllStringSubmatch(text, -1) {
+				if year, _ := strconv.Atoi(m[1]); year <= maxYear {
+					result.Year = year
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+/* classifyLevel returns the canonical level mentioned in normalised text, if any */
+func classifyLevel(text string) string {
+	if m := prePrimaryPattern.FindStringSubmatch(text); m != nil {
+		return "PP" + m[1]
+	}
+	if playgroupPattern.MatchString(text) {
+		return "Playgroup"
+	}
+	if m := gradePattern.FindStringSubmatch(text); m != nil {
+		if grade, _ := strconv.Atoi(m[1]); grade >= 1 && grade <= 12 {
+			return "Grade " + strconv.Itoa(grade)
+		}
+	}
+	if m := formPattern.FindStringSubmatch(text); m != nil {
+		return "Form " + m[1]
+	}
+	return ""
+}
+
+/* firstRule returns the label of the first rule matching normalised text */
+func firstRule(list []classifierRule, text string) string {
+	for _, rule := range list {
+		if rule.pattern.MatchString(text) {
+			return rule.label
+		}
+	}
+	return ""
+}
@@ -2,12 +2,19 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"time"
 
 	"gopkg.in/gomail.v2"
 )
 
+/*
+The Send...Email helpers send their email in a goroutine and return at once,
+so a slow SMTP server never holds up a request; sending failures are logged.
+*/
+
 // SendPasswordResetEmail sends a password reset email to the specified recipient.
 // It generates a reset link using the provided token and the FRONTEND_URL environment variable.
 // The email is sent using SMTP credentials and settings defined in environment variables.
@@ -41,13 +48,184 @@ func SendPasswordResetEmail(email, token string) error {
 	return nil
 }
 
-func SendEmail(to, subject, body string) error {
+// SendVerificationEmail sends a link for confirming the recipient's email
+// address. The link points to FRONTEND_URL/verify-email, which should post the
+// token to /v1/auth/verify-email. The link expires in 24 hours.
+//
+// Parameters:
+//   - email: The address to verify.
+//   - token: The verification token to include in the link.
+//
+// Returns:
+//   - error: Always nil; sending failures are logged.
+func SendVerificationEmail(email, token string) error {
+	verifyLink := fmt.Sprintf("%s/verify-email?token=%s", os.Getenv("FRONTEND_URL"), token)
+	body := fmt.Sprintf("Welcome to CBC Exams! Click <a href='%s'>here</a> to verify your email address. Link expires in 24 hours.", verifyLink)
+
+	go func() {
+		if err := SendEmail(email, "Verify your email address", body); err != nil {
+			fmt.Printf("Failed to send verification email: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// SendEmailChangeEmail sends a link for confirming a new email address on an
+// existing account. It uses the same verify-email page as
+// SendVerificationEmail; the account keeps its current address until the
+// link is opened. The link expires in 24 hours.
+//
+// Parameters:
+//   - email: The new address.
+//   - token: The verification token to include in the link.
+//
+// Returns:
+//   - error: Always nil; sending failures are logged.
+func SendEmailChangeEmail(email, token string) error {
+	verifyLink := fmt.Sprintf("%s/verify-email?token=%s", os.Getenv("FRONTEND_URL"), token)
+	body := fmt.Sprintf("Click <a href='%s'>here</a> to make this the email address of your CBC Exams account. "+
+		"Link expires in 24 hours. If you did not ask for this, you can ignore this email.", verifyLink)
+
+	go func() {
+		if err := SendEmail(email, "Confirm your new email address", body); err != nil {
+			fmt.Printf("Failed to send email change confirmation: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// SendAccountLockedEmail warns a user that their account was locked after too
+// many failed login attempts. It says when the lock expires and links to the
+// password reset page in case the attempts were not theirs.
+//
+// Parameters:
+//   - email: The account's email address.
+//   - until: When the lock expires.
+//
+// Returns:
+//   - error: Always nil; sending failures are logged.
+func SendAccountLockedEmail(email string, until time.Time) error {
+	body := fmt.Sprintf(
+		"Your account was temporarily locked after several failed login attempts. "+
+			"You can try again after %s (EAT). If this wasn't you, "+
+			"<a href='%s/forgot-password'>reset your password</a> to keep your account safe.",
+		until.Format("02 Jan 2006, 15:04"), os.Getenv("FRONTEND_URL"),
+	)
+
+	go func() {
+		if err := SendEmail(email, "Your account has been locked", body); err != nil {
+			fmt.Printf("Failed to send account locked email: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// SendAccountDeletionScheduledEmail confirms a request to delete an account
+// and tells the user how to cancel it: logging in before the erasure date
+// keeps the account.
+//
+// Parameters:
+//   - email: The account's email address.
+//   - scheduledFor: When the account's personal data will be erased.
+//
+// Returns:
+//   - error: Always nil; sending failures are logged.
+func SendAccountDeletionScheduledEmail(email string, scheduledFor time.Time) error {
+	body := fmt.Sprintf(
+		"We received a request to delete your account. Your personal data will be erased on %s (EAT). "+
+			"To keep your account, simply <a href='%s/login'>log in</a> before then. "+
+			"If you did not make this request, log in and change your password.",
+		scheduledFor.Format("02 Jan 2006, 15:04"), os.Getenv("FRONTEND_URL"),
+	)
+
+	go func() {
+		if err := SendEmail(email, "Your account is scheduled for deletion", body); err != nil {
+			fmt.Printf("Failed to send account deletion email: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// SendRenewalFailedEmail tells a subscriber that Pesapal could not charge the
+// automatic renewal of their plan, that access ends with the current period
+// and where to renew by hand.
+//
+// Parameters:
+//   - email: The subscriber's email address.
+//   - planName: The display name of the plan that failed to renew.
+//
+// Returns:
+//   - error: Always nil; sending failures are logged.
+func SendRenewalFailedEmail(email, planName string) error {
+	body := fmt.Sprintf(
+		"We could not process the automatic renewal of your <b>%s</b> subscription. "+
+			"Your premium access will end when the current period expires. "+
+			"Visit <a href='%s/subscriptions'>your subscription page</a> to renew manually.",
+		planName, os.Getenv("FRONTEND_URL"),
+	)
+
+	go func() {
+		if err := SendEmail(email, "Subscription renewal failed", body); err != nil {
+			fmt.Printf("Failed to send renewal failure email: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// SendReceiptEmail emails a payment receipt: the HTML receipt is the body and
+// its PDF copy is attached as <number>.pdf.
+//
+// Parameters:
+//   - email: The customer's email address.
+//   - number: The receipt number, used in the subject and attachment name.
+//   - html: The receipt rendered as HTML, used as the email body.
+//   - pdf: The receipt rendered as a PDF.
+//
+// Returns:
+//   - error: Always nil; sending failures are logged.
+func SendReceiptEmail(email, number, html string, pdf []byte) error {
+	go func() {
+		attachment := Attachment{Filename: number + ".pdf", ContentType: "application/pdf", Content: pdf}
+		if err := SendEmail(email, "Your receipt "+number, html, attachment); err != nil {
+			fmt.Printf("Failed to send receipt email: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+/* Attachment is a file sent along with an email */
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// SendEmail sends an HTML email through the SMTP server configured in the
+// environment, with any attachments added as files.
+func SendEmail(to, subject, body string, attachments ...Attachment) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", os.Getenv("SMTP_FROM"))
 	m.SetHeader("To", to)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body)
 
+	for _, attachment := range attachments {
+		content := attachment.Content
+		m.Attach(attachment.Filename,
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+		)
+	}
+
 	/* Convert SMTP_PORT to an integer */
 	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
 	if err != nil {
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"time"
 
@@ -10,26 +12,165 @@ import (
 
 var JWT_SECRET = os.Getenv("JWT_SECRET")
 
-// GenerateJWT generates a JSON Web Token (JWT) for the given user ID.
-// The token is signed using the HS256 signing method and includes the
-// user ID as a claim, along with an expiration time set to 72 hours
-// (3 days) from the time of generation.
+/* Lifetime of access tokens; clients renew them with their refresh token */
+const AccessTokenTTL = 15 * time.Minute
+
+// GenerateJWT generates a short-lived JSON Web Token (JWT) for the given user
+// ID. The token is signed using the HS256 signing method and includes the
+// user ID, role and session ID as claims, along with an expiration time set
+// to AccessTokenTTL from the time of generation.
 //
 // Parameters:
 //   - userID: A UUID representing the unique identifier of the user.
+//   - role: The user's role, checked by middleware.RequireRole.
+//   - sessionID: The session the token belongs to; revoking the session
+//     invalidates the token.
 //
 // Returns:
 //   - A string containing the signed JWT.
 //   - An error if the token signing process fails.
-func GenerateJWT(userID uuid.UUID) (string, error) {
+func GenerateJWT(userID uuid.UUID, role string, sessionID uuid.UUID) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(), /* 3 days expiry */
+		"role":    role,
+		"sid":     sessionID,
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
 	})
 
 	return token.SignedString([]byte(JWT_SECRET))
 }
 
+/* Purpose claims of single-use tokens; middleware.JWTAuth rejects any token with a purpose */
+const (
+	EmailVerificationPurpose = "verify_email"
+	OIDCStatePurpose         = "oidc_state"
+	TwoFactorPurpose         = "two_factor"
+)
+
+/* generatePurposeToken signs claims for a single-purpose token valid for ttl */
+func generatePurposeToken(purpose string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	claims["purpose"] = purpose
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(JWT_SECRET))
+}
+
+/* parsePurposeToken validates a token created by generatePurposeToken for the given purpose */
+func parsePurposeToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	token, err := ValidateJWT(tokenString)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return nil, fmt.Errorf("not a %s token", purpose)
+	}
+	return claims, nil
+}
+
+// GenerateEmailVerificationToken creates a signed token that proves the holder
+// received an email at the given address. The token expires after 24 hours and
+// carries a "purpose" claim so it cannot be used as a login token.
+//
+// Parameters:
+//   - userID: The user whose email address is being verified.
+//   - email: The address the token is sent to.
+//
+// Returns:
+//   - A string containing the signed token.
+//   - An error if the token signing process fails.
+func GenerateEmailVerificationToken(userID uuid.UUID, email string) (string, error) {
+	return generatePurposeToken(EmailVerificationPurpose, jwt.MapClaims{
+		"user_id": userID,
+		"email":   email,
+	}, 24*time.Hour)
+}
+
+// ParseEmailVerificationToken validates a token created by
+// GenerateEmailVerificationToken and returns the user ID and email it was
+// issued for.
+//
+// Returns:
+//   - uuid.UUID: The user the token was issued to.
+//   - string: The email address the token was sent to.
+//   - error: An error if the token is invalid, expired or not a verification token.
+func ParseEmailVerificationToken(tokenString string) (uuid.UUID, string, error) {
+	claims, err := parsePurposeToken(tokenString, EmailVerificationPurpose)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, "", errors.New("invalid user in token")
+	}
+
+	email, _ := claims["email"].(string)
+	return userID, email, nil
+}
+
+// GenerateOIDCStateToken signs the state and nonce of an external sign-in so
+// they can be kept in a cookie until the provider redirects back. The token
+// expires after 10 minutes.
+func GenerateOIDCStateToken(state, nonce string) (string, error) {
+	return generatePurposeToken(OIDCStatePurpose, jwt.MapClaims{
+		"state": state,
+		"nonce": nonce,
+	}, 10*time.Minute)
+}
+
+// ParseOIDCStateToken validates a token created by GenerateOIDCStateToken and
+// returns the state and nonce it holds.
+func ParseOIDCStateToken(tokenString string) (string, string, error) {
+	claims, err := parsePurposeToken(tokenString, OIDCStatePurpose)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, _ := claims["state"].(string)
+	nonce, _ := claims["nonce"].(string)
+	if state == "" || nonce == "" {
+		return "", "", errors.New("incomplete state token")
+	}
+	return state, nonce, nil
+}
+
+/* How long a user has to enter their two-factor code after the password */
+const TwoFactorTokenTTL = 5 * time.Minute
+
+// GenerateTwoFactorToken creates the token returned by the first login step
+// of an account with two-factor authentication. Exchanging it together with
+// a valid code completes the login. The token expires after TwoFactorTokenTTL.
+//
+// Parameters:
+//   - userID: The user who passed the first step.
+//   - device: The device name given at login, kept for the session.
+func GenerateTwoFactorToken(userID uuid.UUID, device string) (string, error) {
+	return generatePurposeToken(TwoFactorPurpose, jwt.MapClaims{
+		"user_id": userID,
+		"device":  device,
+	}, TwoFactorTokenTTL)
+}
+
+// ParseTwoFactorToken validates a token created by GenerateTwoFactorToken and
+// returns the user ID and device name it holds.
+func ParseTwoFactorToken(tokenString string) (uuid.UUID, string, error) {
+	claims, err := parsePurposeToken(tokenString, TwoFactorPurpose)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, "", errors.New("invalid user in token")
+	}
+
+	device, _ := claims["device"].(string)
+	return userID, device, nil
+}
+
 // ValidateJWT validates a given JWT token string and returns the parsed token
 // if it is valid, or an error if the validation fails.
 //
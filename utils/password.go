@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordsFile string
+
+/* commonPasswords is the embedded denylist, loaded once at startup */
+var commonPasswords = func() map[string]bool {
+	set := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			set[strings.ToLower(line)] = true
+		}
+	}
+	return set
+}()
+
+/* bcrypt ignores everything after 72 bytes, so longer passwords are refused rather than silently truncated */
+const passwordMaxBytes = 72
+
+/*
+PasswordPolicy holds the rules new passwords must meet. Configured with
+PASSWORD_MIN_LENGTH (default 8) and PASSWORD_BREACH_CHECK ("true" to reject
+passwords found in the Have I Been Pwned breach corpus).
+*/
+type PasswordPolicy struct {
+	MinLength   int
+	BreachCheck bool
+}
+
+// PasswordPolicyFromEnv returns the password policy configured in the
+// environment.
+func PasswordPolicyFromEnv() PasswordPolicy {
+	minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	if err != nil || minLength <= 0 {
+		minLength = 8
+	}
+	return PasswordPolicy{
+		MinLength:   minLength,
+		BreachCheck: strings.ToLower(os.Getenv("PASSWORD_BREACH_CHECK")) == "true",
+	}
+}
+
+// ValidatePassword checks a new password against the policy configured in
+// the environment. See PasswordPolicy.Validate.
+func ValidatePassword(password string, personal ...string) error {
+	return PasswordPolicyFromEnv().Validate(password, personal...)
+}
+
+// Validate checks a new password against the policy.
+//
+// Parameters:
+//   - password: The password the user chose.
+//   - personal: Values the password must not equal, such as the user's
+//     email address or name. Empty values are ignored.
+//
+// Returns:
+//   - error: A message suitable for showing to the user, or nil if the
+//     password is acceptable. A failed breach lookup does not reject the
+//     password.
+func (p PasswordPolicy) Validate(password string, personal ...string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return fmt.Errorf("password must be at most %d bytes long", passwordMaxBytes)
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return fmt.Errorf("password is too common; choose a less guessable one")
+	}
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		if lower == value || (strings.Contains(value, "@") && lower == strings.SplitN(value, "@", 2)[0]) {
+			return fmt.Errorf("password must not be your name or email address")
+		}
+	}
+
+	if p.BreachCheck {
+		breached, err := PasswordBreached(password)
+		if err != nil {
+			log.Printf("Password breach check failed: %v", err)
+		} else if breached {
+			return fmt.Errorf("password has appeared in a data breach; choose a different one")
+		}
+	}
+	return nil
+}
+
+var breachHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// PasswordBreached reports whether a password appears in the Have I Been
+// Pwned breach corpus. Only the first five characters of the password's
+// SHA-1 hash are sent (k-anonymity range query).
+//
+// Returns:
+//   - bool: Whether the password was found.
+//   - error: An error if the lookup failed.
+func PasswordBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequest(http.MethodGet, "https://api.pwnedpasswords.com/range/"+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := breachHTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breach lookup returned %d", resp.StatusCode)
+	}
+
+	/* Each line is "SUFFIX:COUNT"; padding entries have a count of 0 */
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && candidate == suffix && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
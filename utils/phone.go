@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+/* Kenyan mobile numbers: 7XXXXXXXX or 1XXXXXXXX after the country code */
+var kenyanMobile = regexp.MustCompile(`^[17]\d{8}$`)
+
+// NormalizeKenyanPhone converts a Kenyan mobile number written in any of the
+// common local forms (0712345678, 712345678, 254712345678, +254 712 345 678)
+// to E.164 (+254712345678).
+//
+// Parameters:
+//   - phone: The phone number as typed by the user.
+//
+// Returns:
+//   - string: The number in E.164 format.
+//   - error: An error if the input is not a valid Kenyan mobile number.
+func NormalizeKenyanPhone(phone string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		if r == ' ' || r == '-' || r == '(' || r == ')' || r == '+' {
+			return -1
+		}
+		return 'x'
+	}, phone)
+
+	switch {
+	case strings.HasPrefix(digits, "254"):
+		digits = digits[3:]
+	case strings.HasPrefix(digits, "0"):
+		digits = digits[1:]
+	}
+
+	if !kenyanMobile.MatchString(digits) {
+		return "", errors.New("invalid Kenyan phone number")
+	}
+	return "+254" + digits, nil
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/* SMSSender delivers text messages to phone numbers in E.164 format */
+type SMSSender interface {
+	Send(to, message string) error
+}
+
+// NewSMSSenderFromEnv returns the SMS sender selected by SMS_PROVIDER:
+// "africastalking" for Africa's Talking, anything else for LogSMSSender.
+//
+// Environment Variables:
+//   - SMS_PROVIDER: "africastalking" or "log" (default).
+//   - AT_USERNAME, AT_API_KEY: Africa's Talking credentials.
+//   - AT_SENDER_ID (optional): Registered sender ID or short code.
+//   - AT_BASE_URL (optional): Defaults to https://api.africastalking.com;
+//     use https://api.sandbox.africastalking.com with the "sandbox" username.
+func NewSMSSenderFromEnv() SMSSender {
+	if strings.ToLower(os.Getenv("SMS_PROVIDER")) != "africastalking" {
+		return LogSMSSender{}
+	}
+
+	baseURL := os.Getenv("AT_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.africastalking.com"
+	}
+
+	return &AfricasTalkingSender{
+		Username: os.Getenv("AT_USERNAME"),
+		APIKey:   os.Getenv("AT_API_KEY"),
+		SenderID: os.Getenv("AT_SENDER_ID"),
+		BaseURL:  baseURL,
+	}
+}
+
+/* LogSMSSender prints messages to the log instead of sending them; for development only */
+type LogSMSSender struct{}
+
+func (LogSMSSender) Send(to, message string) error {
+	log.Printf("SMS to %s: %s", to, message)
+	return nil
+}
+
+/* AfricasTalkingSender sends messages through the Africa's Talking bulk SMS API */
+type AfricasTalkingSender struct {
+	Username   string
+	APIKey     string
+	SenderID   string
+	BaseURL    string
+	HTTPClient *http.Client /* Optional; defaults to a client with a 15s timeout */
+}
+
+var smsHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// Send posts one message to the Africa's Talking messaging endpoint and
+// checks that the recipient was accepted.
+//
+// Parameters:
+//   - to: Recipient phone number in E.164 format.
+//   - message: The text to send.
+//
+// Returns:
+//   - error: An error if the request fails or the recipient was rejected.
+func (s *AfricasTalkingSender) Send(to, message string) error {
+	form := url.Values{
+		"username": {s.Username},
+		"to":       {to},
+		"message":  {message},
+	}
+	if s.SenderID != "" {
+		form.Set("from", s.SenderID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.BaseURL, "/")+"/version1/messaging", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create SMS request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", s.APIKey)
+
+	client := s.HTTPClient
+	if client == nil {
+		client = smsHTTPClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("SMS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read SMS response: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS provider returned %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		SMSMessageData struct {
+			Message    string `json:"Message"`
+			Recipients []struct {
+				Number string `json:"number"`
+				Status string `json:"status"`
+			} `json:"Recipients"`
+		} `json:"SMSMessageData"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to decode SMS response: %v", err)
+	}
+
+	recipients := result.SMSMessageData.Recipients
+	if len(recipients) == 0 || recipients[0].Status != "Success" {
+		return fmt.Errorf("SMS not accepted: %s", result.SMSMessageData.Message)
+	}
+	return nil
+}
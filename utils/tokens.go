@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 )
 
@@ -23,3 +24,11 @@ func GenerateRandomToken(length int) string {
 	}
 	return hex.EncodeToString(b)
 }
+
+// HashToken returns the hex-encoded SHA-256 hash of a token. Tokens that grant
+// access (refresh tokens, reset tokens) are stored hashed so a database leak
+// does not leak usable tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}